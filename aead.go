@@ -0,0 +1,228 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	tagSize    = 16
+	maxMessage = 1<<38 - 64 // RFC 8439 plaintext limit
+)
+
+var (
+	// ErrTagMismatch is returned when a message fails authentication,
+	// whether from tampering, the wrong key, nonce, or additional data,
+	// or truncation that leaves a complete but incorrect tag.
+	ErrTagMismatch = errors.New("chacha: message authentication failed")
+
+	// ErrTruncated is returned by DecryptReader when the stream ends
+	// before a complete tag. It wraps io.ErrUnexpectedEOF.
+	ErrTruncated = fmt.Errorf("chacha: truncated stream: %w", io.ErrUnexpectedEOF)
+)
+
+var errTagSize = errors.New("chacha: tag size must be 8 to 16 bytes")
+
+// VerifyTag reports whether two authentication tags are equal, in time
+// that depends only on their lengths, not their contents. Tags of
+// different lengths are never equal. Use this rather than bytes.Equal
+// when checking tags in custom framing, which would otherwise leak how
+// much of a forged tag is correct.
+func VerifyTag(expected, actual []byte) bool {
+	return subtle.ConstantTimeCompare(expected, actual) == 1
+}
+
+// ChaCha-Poly1305 AEAD construction (RFC 8439, section 2.8).
+type aead struct {
+	key    [32]byte
+	rounds int
+	tagLen int // bytes of the Poly1305 tag kept
+}
+
+var _ cipher.AEAD = (*aead)(nil)
+
+// NewAEAD returns a ChaCha-Poly1305 AEAD as specified in RFC 8439, using
+// a 12-byte nonce. With 20 rounds this is the standard ChaCha20-Poly1305.
+// The key must be exactly 32 bytes. Open checks the tag before decrypting
+// anything, so when authentication fails no plaintext is ever written to
+// dst, including its spare capacity.
+func NewAEAD(key []byte, rounds int) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	if !validRounds(rounds) {
+		return nil, ErrRounds
+	}
+	a := &aead{rounds: rounds, tagLen: tagSize}
+	copy(a.key[:], key)
+	return a, nil
+}
+
+// NewAEADWithTagSize is like NewAEAD with 20 rounds, but truncates the
+// Poly1305 tag to tagSize bytes, from 8 to 16, for protocols that cannot
+// afford the full tag. Only the kept bytes are checked by Open. Each byte
+// removed makes forgery 256 times more likely: an 8-byte tag can be
+// forged with probability about 2^-64 per attempt, rather than 2^-128, so
+// limit the number of failed decryptions an attacker may try.
+func NewAEADWithTagSize(key []byte, tagSize int) (cipher.AEAD, error) {
+	if tagSize < 8 || tagSize > 16 {
+		return nil, errTagSize
+	}
+	c, err := NewAEAD(key, 20)
+	if err != nil {
+		return nil, err
+	}
+	a := c.(*aead)
+	a.tagLen = tagSize
+	return a, nil
+}
+
+func (a *aead) NonceSize() int {
+	return NonceSizeIETF
+}
+
+func (a *aead) Overhead() int {
+	return a.tagLen
+}
+
+func (a *aead) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != NonceSizeIETF {
+		panic("chacha: bad nonce length passed to Seal")
+	}
+	if uint64(len(plaintext)) > maxMessage {
+		panic("chacha: plaintext too large")
+	}
+
+	c, mac := a.setup(nonce, additionalData)
+	ret, out := sliceForAppend(dst, len(plaintext)+a.tagLen)
+	ciphertext, tag := out[:len(plaintext)], out[len(plaintext):]
+	c.XORKeyStream(ciphertext, plaintext)
+	mac.update(ciphertext)
+	var sum [16]byte
+	finish(mac, len(additionalData), len(ciphertext), &sum)
+	copy(tag, sum[:])
+	return ret
+}
+
+func (a *aead) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != NonceSizeIETF {
+		panic("chacha: bad nonce length passed to Open")
+	}
+	if len(ciphertext) < a.tagLen {
+		return nil, ErrTagMismatch
+	}
+	if uint64(len(ciphertext)) > maxMessage+uint64(a.tagLen) {
+		return nil, ErrTagMismatch
+	}
+
+	tag := ciphertext[len(ciphertext)-a.tagLen:]
+	ciphertext = ciphertext[:len(ciphertext)-a.tagLen]
+
+	c, mac := a.setup(nonce, additionalData)
+	mac.update(ciphertext)
+	var want [16]byte
+	finish(mac, len(additionalData), len(ciphertext), &want)
+	if !VerifyTag(want[:a.tagLen], tag) {
+		return nil, ErrTagMismatch
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	c.XORKeyStream(out, ciphertext)
+	return ret, nil
+}
+
+// XChaCha20-Poly1305 AEAD construction (draft-irtf-cfrg-xchacha).
+type xaead struct {
+	key [32]byte
+}
+
+var _ cipher.AEAD = (*xaead)(nil)
+
+// NewXAEAD returns an XChaCha20-Poly1305 AEAD, which takes a 24-byte
+// nonce, long enough to be chosen at random. It is compatible with
+// libsodium's crypto_aead_xchacha20poly1305_ietf. The first 16 bytes of
+// the nonce derive a subkey via HChaCha20, which then encrypts with the
+// RFC 8439 construction using the remaining 8 bytes. The key must be
+// exactly 32 bytes.
+func NewXAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	x := new(xaead)
+	copy(x.key[:], key)
+	return x, nil
+}
+
+func (x *xaead) NonceSize() int {
+	return NonceSizeX
+}
+
+func (x *xaead) Overhead() int {
+	return tagSize
+}
+
+func (x *xaead) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != NonceSizeX {
+		panic("chacha: bad nonce length passed to Seal")
+	}
+	a, inner := x.inner(nonce)
+	return a.Seal(dst, inner[:], plaintext, additionalData)
+}
+
+func (x *xaead) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != NonceSizeX {
+		panic("chacha: bad nonce length passed to Open")
+	}
+	a, inner := x.inner(nonce)
+	return a.Open(dst, inner[:], ciphertext, additionalData)
+}
+
+// Derives the RFC 8439 AEAD and its 12-byte nonce for a 24-byte nonce.
+func (x *xaead) inner(nonce []byte) (*aead, [NonceSizeIETF]byte) {
+	a := &aead{key: hchacha(x.key[:], nonce, 20), rounds: 20, tagLen: tagSize}
+	var inner [NonceSizeIETF]byte
+	copy(inner[4:], nonce[16:])
+	return a, inner
+}
+
+// Returns a cipher positioned at block 1, and a Poly1305 keyed from block
+// 0 that has absorbed the padded additional data.
+func (a *aead) setup(nonce, additionalData []byte) (*Cipher, *poly1305) {
+	c, _ := NewIETF(a.key[:], nonce, a.rounds)
+	var polyKey [32]byte
+	c.KeyStream(polyKey[:])
+	c.Seek(1)
+
+	mac := newPoly1305(&polyKey)
+	mac.update(additionalData)
+	mac.pad()
+	return c, mac
+}
+
+// Pads the ciphertext, appends both lengths, and writes the tag.
+func finish(mac *poly1305, adlen, ctlen int, tag *[16]byte) {
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:], uint64(adlen))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(ctlen))
+	mac.pad()
+	mac.update(lengths[:])
+	mac.sum(tag)
+}
+
+// Extends in by n bytes, returning the whole slice and the extension.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
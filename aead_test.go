@@ -0,0 +1,223 @@
+package chacha
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAEAD(t *testing.T) {
+	// RFC 8439, section 2.8.2
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(0x80 + i)
+	}
+	nonce := []byte{
+		0x07, 0x00, 0x00, 0x00, 0x40, 0x41, 0x42, 0x43,
+		0x44, 0x45, 0x46, 0x47,
+	}
+	aad := []byte{
+		0x50, 0x51, 0x52, 0x53, 0xc0, 0xc1, 0xc2, 0xc3,
+		0xc4, 0xc5, 0xc6, 0xc7,
+	}
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: " +
+		"If I could offer you only one tip for the future, " +
+		"sunscreen would be it.")
+	want := []byte{
+		0xd3, 0x1a, 0x8d, 0x34, 0x64, 0x8e, 0x60, 0xdb,
+		0x7b, 0x86, 0xaf, 0xbc, 0x53, 0xef, 0x7e, 0xc2,
+		0xa4, 0xad, 0xed, 0x51, 0x29, 0x6e, 0x08, 0xfe,
+		0xa9, 0xe2, 0xb5, 0xa7, 0x36, 0xee, 0x62, 0xd6,
+		0x3d, 0xbe, 0xa4, 0x5e, 0x8c, 0xa9, 0x67, 0x12,
+		0x82, 0xfa, 0xfb, 0x69, 0xda, 0x92, 0x72, 0x8b,
+		0x1a, 0x71, 0xde, 0x0a, 0x9e, 0x06, 0x0b, 0x29,
+		0x05, 0xd6, 0xa5, 0xb6, 0x7e, 0xcd, 0x3b, 0x36,
+		0x92, 0xdd, 0xbd, 0x7f, 0x2d, 0x77, 0x8b, 0x8c,
+		0x98, 0x03, 0xae, 0xe3, 0x28, 0x09, 0x1b, 0x58,
+		0xfa, 0xb3, 0x24, 0xe4, 0xfa, 0xd6, 0x75, 0x94,
+		0x55, 0x85, 0x80, 0x8b, 0x48, 0x31, 0xd7, 0xbc,
+		0x3f, 0xf4, 0xde, 0xf0, 0x8e, 0x4b, 0x7a, 0x9d,
+		0xe5, 0x76, 0xd2, 0x65, 0x86, 0xce, 0xc6, 0x4b,
+		0x61, 0x16,
+		// tag
+		0x1a, 0xe1, 0x0b, 0x59, 0x4f, 0x09, 0xe2, 0x6a,
+		0x7e, 0x90, 0x2e, 0xcb, 0xd0, 0x60, 0x06, 0x91,
+	}
+
+	a, err := NewAEAD(key, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.NonceSize() != NonceSizeIETF || a.Overhead() != 16 {
+		t.Errorf("NonceSize(), Overhead(), got %v, %v, want %v, %v",
+			a.NonceSize(), a.Overhead(), NonceSizeIETF, 16)
+	}
+	got := a.Seal(nil, nonce, plaintext, aad)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Seal(), got %x, want %x", got, want)
+	}
+
+	pt, err := a.Open(nil, nonce, got, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt, plaintext) {
+		t.Errorf("Open(), got %q, want %q", pt, plaintext)
+	}
+
+	// Any tampering is rejected
+	for _, i := range []int{0, 50, len(got) - 1} {
+		got[i] ^= 1
+		if pt, err := a.Open(nil, nonce, got, aad); err == nil || pt != nil {
+			t.Errorf("Open(tampered %d), got %v, %v", i, pt, err)
+		}
+		got[i] ^= 1
+	}
+	if _, err := a.Open(nil, nonce, got, aad[1:]); err == nil {
+		t.Errorf("Open(wrong aad), got nil error")
+	}
+	if _, err := a.Open(nil, nonce, got[:15], aad); err == nil {
+		t.Errorf("Open(short), got nil error")
+	}
+}
+
+func TestXAEAD(t *testing.T) {
+	// draft-irtf-cfrg-xchacha-03, section A.3.1
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(0x80 + i)
+	}
+	nonce := make([]byte, 24)
+	for i := range nonce {
+		nonce[i] = byte(0x40 + i)
+	}
+	aad, _ := hex.DecodeString("50515253c0c1c2c3c4c5c6c7")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: " +
+		"If I could offer you only one tip for the future, " +
+		"sunscreen would be it.")
+	want, _ := hex.DecodeString("" +
+		"bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb" +
+		"731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b452" +
+		"2f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff9" +
+		"21f9664c97637da9768812f615c68b13b52e" +
+		// tag
+		"c0875924c1c7987947deafd8780acf49")
+
+	a, err := NewXAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.NonceSize() != NonceSizeX {
+		t.Errorf("NonceSize(), got %v, want %v", a.NonceSize(), NonceSizeX)
+	}
+	got := a.Seal(nil, nonce, plaintext, aad)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Seal(), got %x, want %x", got, want)
+	}
+	pt, err := a.Open(nil, nonce, got, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pt, plaintext) {
+		t.Errorf("Open(), got %q, want %q", pt, plaintext)
+	}
+
+	got[0] ^= 1
+	if _, err := a.Open(nil, nonce, got, aad); err == nil {
+		t.Errorf("Open(modified), got nil error")
+	}
+	if _, err := NewXAEAD(key[:16]); err != ErrKeySize {
+		t.Errorf("NewXAEAD(short key), got %v, want %v", err, ErrKeySize)
+	}
+}
+
+func TestVerifyTag(t *testing.T) {
+	tag := []byte("0123456789abcdef")
+	tests := []struct {
+		actual []byte
+		want   bool
+	}{
+		{[]byte("0123456789abcdef"), true},
+		{[]byte("0123456789abcdeF"), false},
+		{[]byte("x123456789abcdef"), false},
+		{[]byte("0123456789abcde"), false},
+		{[]byte("0123456789abcdef0"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if got := VerifyTag(tag, test.actual); got != test.want {
+			t.Errorf("VerifyTag(%q, %q), got %v, want %v",
+				tag, test.actual, got, test.want)
+		}
+	}
+}
+
+func TestAEADWithTagSize(t *testing.T) {
+	key := make([]byte, 32)
+	nonce := make([]byte, 12)
+	plaintext := []byte("truncated tags")
+	aad := []byte("aad")
+
+	full, _ := NewAEAD(key, 20)
+	want := full.Seal(nil, nonce, plaintext, aad)
+
+	for _, size := range []int{7, 17, 0} {
+		if _, err := NewAEADWithTagSize(key, size); err == nil {
+			t.Errorf("NewAEADWithTagSize(%d) succeeded", size)
+		}
+	}
+	for size := 8; size <= 16; size++ {
+		a, err := NewAEADWithTagSize(key, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Overhead() != size {
+			t.Errorf("Overhead(), got %d, want %d", a.Overhead(), size)
+		}
+		sealed := a.Seal(nil, nonce, plaintext, aad)
+		if !bytes.Equal(sealed, want[:len(plaintext)+size]) {
+			t.Errorf("Seal(tag %d), got %x, want prefix of %x", size, sealed, want)
+		}
+		opened, err := a.Open(nil, nonce, sealed, aad)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Errorf("Open(tag %d), got %q, %v", size, opened, err)
+		}
+		sealed[len(sealed)-1] ^= 1
+		if _, err := a.Open(nil, nonce, sealed, aad); err != ErrTagMismatch {
+			t.Errorf("Open(tag %d, forged), got %v, want %v", size, err, ErrTagMismatch)
+		}
+	}
+}
+
+func TestOpenFailureWritesNothing(t *testing.T) {
+	key := make([]byte, 32)
+	plaintext := bytes.Repeat([]byte("secret"), 20)
+	aeads := []struct {
+		name  string
+		nonce []byte
+		new   func([]byte) (cipher.AEAD, error)
+	}{
+		{"AEAD", make([]byte, 12), func(k []byte) (cipher.AEAD, error) {
+			return NewAEAD(k, 20)
+		}},
+		{"XAEAD", make([]byte, 24), NewXAEAD},
+	}
+	for _, x := range aeads {
+		a, _ := x.new(key)
+		sealed := a.Seal(nil, x.nonce, plaintext, nil)
+		sealed[len(sealed)-1] ^= 1
+
+		scratch := bytes.Repeat([]byte{0xaa}, 4+len(sealed))
+		dst := scratch[:4]
+		if out, err := a.Open(dst, x.nonce, sealed, nil); err == nil || out != nil {
+			t.Errorf("%s Open(forged), got %q, %v", x.name, out, err)
+		}
+		for i, b := range scratch {
+			if b != 0xaa {
+				t.Errorf("%s Open(forged) wrote to dst[%d]", x.name, i)
+				break
+			}
+		}
+	}
+}
@@ -1,36 +1,432 @@
 // This is free and unencumbered software released into the public domain.
 
-// Package chacha implements the ChaCha stream cipher.
+// Package chacha implements the ChaCha stream cipher and the
+// ChaCha20-Poly1305 AEAD.
+//
+// All operations run in constant time with respect to secret data. The
+// core uses only additions, XORs, and fixed rotations, keystream is
+// combined with data using crypto/subtle.XORBytes, and authentication
+// tags are compared with crypto/subtle.ConstantTimeCompare. Branches and
+// memory accesses depend only on lengths, stream positions, and the
+// round count, never on key, nonce, or message bytes.
 package chacha
 
 import (
+	"bytes"
+	"context"
 	"crypto/cipher"
+	"crypto/subtle"
 	"encoding/binary"
 	"errors"
 	"io"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+	"unsafe"
 )
 
 // avail replaced with nextByte by Ron Charlton, public domain 2022-09-06,
 // a 25 percentage point speedup.
 
+const (
+	// KeySize is the size of a ChaCha key in bytes.
+	KeySize = 32
+
+	// NonceSize is the size of an original ChaCha nonce (IV) in bytes.
+	NonceSize = 8
+
+	// NonceSizeIETF is the size of an RFC 8439 nonce in bytes.
+	NonceSizeIETF = 12
+
+	// NonceSizeX is the size of an XChaCha nonce in bytes.
+	NonceSizeX = 24
+
+	// BlockSize is the size of a ChaCha keystream block in bytes.
+	BlockSize = 64
+)
+
 // Cipher is an instance of the ChaCha stream cipher. It implements both
 // the io.Reader and crypto/cipher.Stream interfaces.
 type Cipher struct {
 	input    [16]uint32
-	output   [64]byte
+	output   [BlockSize]byte
 	nextByte int
 	rounds   int
-	eof      bool
+	eof      bool // the final block has been generated
+	ietf     bool // 32-bit counter, 96-bit nonce (RFC 8439)
+	wrap     bool // continue from block 0 on counter overflow
+	salsa    bool // Salsa20 rather than ChaCha rounds
+	unbuf    bool // whole blocks only, output never holds keystream
+	zeroed   bool // wiped by Zeroize, generates nothing until Rekey
 }
 
 var _ cipher.Stream = (*Cipher)(nil)
 var _ io.Reader = (*Cipher)(nil)
+var _ io.WriterTo = (*Cipher)(nil)
+var _ io.ByteReader = (*Cipher)(nil)
+
+var (
+	// ErrKeySize is returned when a key is the wrong size.
+	ErrKeySize = errors.New("chacha: invalid key size")
+
+	// ErrNonceSize is returned when a nonce (IV) is the wrong size.
+	ErrNonceSize = errors.New("chacha: invalid nonce size")
+
+	// ErrRounds is returned when the number of rounds is not one of the
+	// standard 8, 12, or 20. Other counts, particularly odd ones, do not
+	// produce a standard cipher.
+	ErrRounds = errors.New("chacha: rounds must be 8, 12, or 20")
+
+	// ErrExhausted is returned, or used as the panic value, when an
+	// operation runs past the end of the keystream. Read reports io.EOF
+	// instead.
+	ErrExhausted = errors.New("chacha: keystream exhausted")
+
+	// ErrAlreadyExhausted is the panic value when XORKeyStream is called
+	// on a cipher whose keystream had already run out.
+	ErrAlreadyExhausted = errors.New("chacha: cipher already exhausted")
+
+	// ErrUnaligned is the panic value when a cipher from NewUnbuffered is
+	// asked for anything other than whole blocks, and the error SeekTo and
+	// SeekRelative return in that case.
+	ErrUnaligned = errors.New("chacha: length not a multiple of the block size")
+)
+
+var (
+	errSeekNegative = errors.New("chacha: seek before start of keystream")
+	errSeekRange    = errors.New("chacha: seek offset out of range")
+	errWhence       = errors.New("chacha: invalid whence")
+)
 
 // New returns an initialized instance of a new ChaCha cipher. A ChaCha
 // key is 32 bytes and a ChaCha IV is 8 bytes, so len(key) must be >= 32
-// and len(iv) must be >= 8. Rounds should be one of 8, 12, or 20.
+// and len(iv) must be >= 8. Rounds should be one of 8, 12, or 20. New
+// panics if any of these are violated. Unlike NewCipher, New ignores any
+// bytes past the first 32 of the key and 8 of the IV.
 func New(key, iv []byte, rounds int) *Cipher {
+	if len(key) > KeySize {
+		key = key[:KeySize]
+	}
+	if len(iv) > NonceSize {
+		iv = iv[:NonceSize]
+	}
+	c, err := NewCipher(key, iv, rounds)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewCipher is like New, but returns an error rather than panicking when
+// the key is not exactly 32 bytes (ErrKeySize), the IV is not exactly 8
+// bytes (ErrNonceSize), or the number of rounds is not one of 8, 12, or
+// 20 (ErrRounds). Oversized keys and IVs are rejected rather than
+// truncated, so that key material is never silently ignored. As the
+// specification requires, every 4 bytes of key and IV are read as a
+// little endian word. See NewFromWords to supply the words directly.
+func NewCipher(key, iv []byte, rounds int) (*Cipher, error) {
 	c := new(Cipher)
+	if err := c.Rekey(key, iv, rounds); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Rekey re-initializes the cipher in place with a new key, IV, and round
+// count, validated as in NewCipher. Afterwards the cipher is equivalent to
+// one freshly returned by NewCipher, allowing Cipher values to be reused
+// without allocation. On error the cipher is left unchanged.
+func (c *Cipher) Rekey(key, iv []byte, rounds int) error {
+	if len(key) != KeySize {
+		return ErrKeySize
+	}
+	if len(iv) != NonceSize {
+		return ErrNonceSize
+	}
+	if !validRounds(rounds) {
+		return ErrRounds
+	}
+
+	*c = Cipher{}
+	c.init(key, rounds)
+	c.input[14] = binary.LittleEndian.Uint32(iv[0:])
+	c.input[15] = binary.LittleEndian.Uint32(iv[4:])
+	return nil
+}
+
+// SetNonce replaces the nonce (IV) while keeping the key, and rewinds the
+// cipher to the start of the new keystream. The nonce must be exactly 12
+// bytes for IETF ciphers, and exactly 8 bytes otherwise. For a cipher
+// from NewX this sets only the final 8 bytes of the XChaCha nonce, since
+// the rest was used to derive the key, so a full 24-byte nonce is
+// rejected. On error the cipher is left unchanged.
+func (c *Cipher) SetNonce(iv []byte) error {
+	if c.ietf {
+		if len(iv) != NonceSizeIETF {
+			return ErrNonceSize
+		}
+		c.input[13] = binary.LittleEndian.Uint32(iv[0:])
+		iv = iv[4:]
+	} else if len(iv) != NonceSize {
+		return ErrNonceSize
+	}
+	c.input[14] = binary.LittleEndian.Uint32(iv[0:])
+	c.input[15] = binary.LittleEndian.Uint32(iv[4:])
+	c.SetCounter(0)
+	return nil
+}
+
+// NewPadded is like NewCipher, but accepts an IV shorter than 8 bytes,
+// padding it on the right with zeros: the IV bytes come first, as with
+// copy into a zeroed 8-byte array. This is only for interoperating with
+// peers that send short nonces, which leave fewer distinct nonces and so
+// raise the risk of reuse. IVs longer than 8 bytes are still rejected.
+func NewPadded(key, shortIV []byte, rounds int) (*Cipher, error) {
+	if len(shortIV) > NonceSize {
+		return nil, ErrNonceSize
+	}
+	var iv [NonceSize]byte
+	copy(iv[:], shortIV)
+	return NewCipher(key, iv[:], rounds)
+}
+
+// NewChaCha8 returns a ChaCha cipher with 8 rounds. See NewCipher.
+func NewChaCha8(key, iv []byte) (*Cipher, error) {
+	return NewCipher(key, iv, 8)
+}
+
+// NewChaCha12 returns a ChaCha cipher with 12 rounds. See NewCipher.
+func NewChaCha12(key, iv []byte) (*Cipher, error) {
+	return NewCipher(key, iv, 12)
+}
+
+// NewChaCha20 returns a ChaCha cipher with 20 rounds, the standard
+// choice. See NewCipher.
+func NewChaCha20(key, iv []byte) (*Cipher, error) {
+	return NewCipher(key, iv, 20)
+}
+
+// NewFromReader returns a cipher with a key and IV read from r, typically
+// crypto/rand.Reader, along with that key and IV so that they may be
+// stored for decryption. Any error from reading r is returned as is, and
+// the rounds are validated before anything is read.
+func NewFromReader(r io.Reader, rounds int) (c *Cipher, key [KeySize]byte, iv [NonceSize]byte, err error) {
+	if !validRounds(rounds) {
+		err = ErrRounds
+		return
+	}
+	if _, err = io.ReadFull(r, key[:]); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(r, iv[:]); err != nil {
+		return
+	}
+	c = New(key[:], iv[:], rounds)
+	return
+}
+
+// NewFromWords returns a ChaCha cipher from a key and IV already parsed
+// into 32-bit words, skipping the little endian decoding done by New. The
+// key words occupy state words 4 through 11, the IV words 14 and 15, and
+// the block counter, starting at zero, words 12 and 13. It panics if the
+// rounds are not one of 8, 12, or 20.
+func NewFromWords(keyWords [8]uint32, nonceWords [2]uint32, rounds int) *Cipher {
+	if !validRounds(rounds) {
+		panic(ErrRounds)
+	}
+	c := new(Cipher)
+	var zero [KeySize]byte
+	c.init(zero[:], rounds)
+	copy(c.input[4:12], keyWords[:])
+	c.input[14] = nonceWords[0]
+	c.input[15] = nonceWords[1]
+	return c
+}
+
+// NewKey128 returns a ChaCha cipher using a 128-bit key, which must be
+// exactly 16 bytes. This variant uses the "expand 16-byte k" constants
+// and repeats the key to fill the state. It is weaker than a 256-bit key
+// and only intended for interoperability. The IV must be exactly 8
+// bytes.
+func NewKey128(key16, iv []byte, rounds int) (*Cipher, error) {
+	if len(key16) != 16 {
+		return nil, ErrKeySize
+	}
+	if len(iv) != NonceSize {
+		return nil, ErrNonceSize
+	}
+	if !validRounds(rounds) {
+		return nil, ErrRounds
+	}
+
+	c := new(Cipher)
+	c.input[0] = 0x61707865 // "expand 16-byte k"
+	c.input[1] = 0x3120646e //
+	c.input[2] = 0x79622d36 //
+	c.input[3] = 0x6b206574 //
+	c.input[4] = binary.LittleEndian.Uint32(key16[0:])
+	c.input[5] = binary.LittleEndian.Uint32(key16[4:])
+	c.input[6] = binary.LittleEndian.Uint32(key16[8:])
+	c.input[7] = binary.LittleEndian.Uint32(key16[12:])
+	c.input[8] = c.input[4]
+	c.input[9] = c.input[5]
+	c.input[10] = c.input[6]
+	c.input[11] = c.input[7]
+	c.input[14] = binary.LittleEndian.Uint32(iv[0:])
+	c.input[15] = binary.LittleEndian.Uint32(iv[4:])
+	c.rounds = rounds
+	c.nextByte = len(c.output)
+	return c, nil
+}
+
+// NewIETF returns a ChaCha cipher using the RFC 8439 layout: a 12-byte
+// nonce and a 32-bit block counter. This is the variant used by TLS and
+// WireGuard. The keystream is exhausted after 2^38 bytes (256 GiB), and
+// Seek takes its block argument modulo 2^32. The key must be exactly 32
+// bytes, and the nonce exactly 12 bytes.
+func NewIETF(key, nonce []byte, rounds int) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	if len(nonce) != NonceSizeIETF {
+		return nil, ErrNonceSize
+	}
+	if !validRounds(rounds) {
+		return nil, ErrRounds
+	}
+
+	c := new(Cipher)
+	c.init(key, rounds)
+	c.input[13] = binary.LittleEndian.Uint32(nonce[0:])
+	c.input[14] = binary.LittleEndian.Uint32(nonce[4:])
+	c.input[15] = binary.LittleEndian.Uint32(nonce[8:])
+	c.ietf = true
+	return c, nil
+}
+
+// NewCounter32 returns a ChaCha cipher with the original 8-byte IV but
+// only a 32-bit block counter, as used by some nonstandard
+// implementations. The word after the counter is held at zero, so this
+// is exactly NewIETF with a nonce of 4 zero bytes followed by the IV, and
+// behaves as a NewIETF cipher in every other respect, including
+// exhaustion after 2^38 bytes and SetNonce taking a 12-byte nonce.
+func NewCounter32(key, iv []byte, rounds int) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	if len(iv) != NonceSize {
+		return nil, ErrNonceSize
+	}
+	var nonce [NonceSizeIETF]byte
+	copy(nonce[4:], iv)
+	return NewIETF(key, nonce[:], rounds)
+}
+
+// NewWithCounter is like NewCipher, but starts the keystream at block
+// counter rather than block 0, as if followed by Seek(counter).
+func NewWithCounter(key, iv []byte, counter uint64, rounds int) (*Cipher, error) {
+	c, err := NewCipher(key, iv, rounds)
+	if err != nil {
+		return nil, err
+	}
+	c.Seek(counter)
+	return c, nil
+}
+
+// NewUnbuffered is like NewCipher, but returns a cipher that never
+// buffers keystream between calls, for memory-constrained systems that
+// only process whole blocks. Each call generates blocks directly into its
+// output, so no keystream is left behind in the cipher. XORKeyStream,
+// KeyStream, and Read require lengths that are a multiple of 64 bytes,
+// and seeking requires block-aligned positions. Anything else, including
+// ReadByte, panics with ErrUnaligned.
+func NewUnbuffered(key, iv []byte, rounds int) (*Cipher, error) {
+	c, err := NewCipher(key, iv, rounds)
+	if err != nil {
+		return nil, err
+	}
+	c.unbuf = true
+	return c, nil
+}
+
+// NewX returns an XChaCha cipher, which accepts a 24-byte nonce. Nonces
+// this large may be chosen at random without practical risk of reuse. The
+// key and the first 16 bytes of the nonce derive a subkey via HChaCha,
+// which, with the last 8 bytes of the nonce, initializes a regular ChaCha
+// cipher. The key must be exactly 32 bytes and the nonce exactly 24
+// bytes.
+func NewX(key, nonce []byte, rounds int) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	if len(nonce) != NonceSizeX {
+		return nil, ErrNonceSize
+	}
+	if !validRounds(rounds) {
+		return nil, ErrRounds
+	}
+	subkey := hchacha(key, nonce, rounds)
+	return NewCipher(subkey[:], nonce[16:], rounds)
+}
+
+// HChaCha20 computes the HChaCha function over a 32-byte key and 16-byte
+// nonce, producing a 32-byte subkey. This is the building block of
+// XChaCha (see NewX), exposed for other nonce-extended constructions.
+// Despite the name, rounds may be any of 8, 12, or 20.
+func HChaCha20(key, nonce16 []byte, rounds int) ([32]byte, error) {
+	if len(key) != KeySize {
+		return [32]byte{}, ErrKeySize
+	}
+	if len(nonce16) != 16 {
+		return [32]byte{}, ErrNonceSize
+	}
+	if !validRounds(rounds) {
+		return [32]byte{}, ErrRounds
+	}
+	return hchacha(key, nonce16, rounds), nil
+}
+
+// Computes HChaCha over a key and the first 16 bytes of a nonce.
+func hchacha(key, nonce []byte, rounds int) [32]byte {
+	var x [16]uint32
+	x[0] = 0x61707865
+	x[1] = 0x3320646e
+	x[2] = 0x79622d32
+	x[3] = 0x6b206574
+	for i := 0; i < 8; i++ {
+		x[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	for i := 0; i < 4; i++ {
+		x[12+i] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+	for i := rounds; i > 0; i -= 2 {
+		x[0], x[4], x[8], x[12] = quarterRound(x[0], x[4], x[8], x[12])
+		x[1], x[5], x[9], x[13] = quarterRound(x[1], x[5], x[9], x[13])
+		x[2], x[6], x[10], x[14] = quarterRound(x[2], x[6], x[10], x[14])
+		x[3], x[7], x[11], x[15] = quarterRound(x[3], x[7], x[11], x[15])
+		x[0], x[5], x[10], x[15] = quarterRound(x[0], x[5], x[10], x[15])
+		x[1], x[6], x[11], x[12] = quarterRound(x[1], x[6], x[11], x[12])
+		x[2], x[7], x[8], x[13] = quarterRound(x[2], x[7], x[8], x[13])
+		x[3], x[4], x[9], x[14] = quarterRound(x[3], x[4], x[9], x[14])
+	}
+
+	var out [32]byte
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint32(out[i*4:], x[i])
+		binary.LittleEndian.PutUint32(out[16+i*4:], x[12+i])
+	}
+	return out
+}
+
+// Reports whether rounds is a standard round count.
+func validRounds(rounds int) bool {
+	return rounds == 8 || rounds == 12 || rounds == 20
+}
+
+// Loads the constants and key into a fresh cipher, leaving the counter
+// and nonce words zero.
+func (c *Cipher) init(key []byte, rounds int) {
 	c.input[0] = 0x61707865 // "expand 32-byte k"
 	c.input[1] = 0x3320646e //
 	c.input[2] = 0x79622d32 //
@@ -43,154 +439,1079 @@ func New(key, iv []byte, rounds int) *Cipher {
 	c.input[9] = binary.LittleEndian.Uint32(key[20:])
 	c.input[10] = binary.LittleEndian.Uint32(key[24:])
 	c.input[11] = binary.LittleEndian.Uint32(key[28:])
-	c.input[14] = binary.LittleEndian.Uint32(iv[0:])
-	c.input[15] = binary.LittleEndian.Uint32(iv[4:])
 	c.rounds = rounds
 	c.nextByte = len(c.output)
-	return c
 }
 
-// Fills the output field with the next block and sets avail accordingly.
-func (c *Cipher) next() error {
-	if c.eof {
-		return errors.New("exhausted keystream")
-	}
-
-	var x [16]uint32 // work space
-	for i := 0; i < 16; i++ {
-		x[i] = c.input[i]
-	}
-	for i := c.rounds; i > 0; i -= 2 {
-		// explicit manipulation of x inserted by Ron Charlton, public
-		// domain 2022-09-06. 37% speedup.
-		x[0] = x[0] + x[4]
-		x[12] = ((x[12] ^ x[0]) << 16) | ((x[12] ^ x[0]) >> (32 - 16))
-		x[8] = x[8] + x[12]
-		x[4] = ((x[4] ^ x[8]) << 12) | ((x[4] ^ x[8]) >> (32 - 12))
-		x[0] = x[0] + x[4]
-		x[12] = ((x[12] ^ x[0]) << 8) | ((x[12] ^ x[0]) >> (32 - 8))
-		x[8] = x[8] + x[12]
-		x[4] = ((x[4] ^ x[8]) << 7) | ((x[4] ^ x[8]) >> (32 - 7))
-
-		x[1] = x[1] + x[5]
-		x[13] = ((x[13] ^ x[1]) << 16) | ((x[13] ^ x[1]) >> (32 - 16))
-		x[9] = x[9] + x[13]
-		x[5] = ((x[5] ^ x[9]) << 12) | ((x[5] ^ x[9]) >> (32 - 12))
-		x[1] = x[1] + x[5]
-		x[13] = ((x[13] ^ x[1]) << 8) | ((x[13] ^ x[1]) >> (32 - 8))
-		x[9] = x[9] + x[13]
-		x[5] = ((x[5] ^ x[9]) << 7) | ((x[5] ^ x[9]) >> (32 - 7))
-
-		x[2] = x[2] + x[6]
-		x[14] = ((x[14] ^ x[2]) << 16) | ((x[14] ^ x[2]) >> (32 - 16))
-		x[10] = x[10] + x[14]
-		x[6] = ((x[6] ^ x[10]) << 12) | ((x[6] ^ x[10]) >> (32 - 12))
-		x[2] = x[2] + x[6]
-		x[14] = ((x[14] ^ x[2]) << 8) | ((x[14] ^ x[2]) >> (32 - 8))
-		x[10] = x[10] + x[14]
-		x[6] = ((x[6] ^ x[10]) << 7) | ((x[6] ^ x[10]) >> (32 - 7))
-
-		x[3] = x[3] + x[7]
-		x[15] = ((x[15] ^ x[3]) << 16) | ((x[15] ^ x[3]) >> (32 - 16))
-		x[11] = x[11] + x[15]
-		x[7] = ((x[7] ^ x[11]) << 12) | ((x[7] ^ x[11]) >> (32 - 12))
-		x[3] = x[3] + x[7]
-		x[15] = ((x[15] ^ x[3]) << 8) | ((x[15] ^ x[3]) >> (32 - 8))
-		x[11] = x[11] + x[15]
-		x[7] = ((x[7] ^ x[11]) << 7) | ((x[7] ^ x[11]) >> (32 - 7))
-
-		x[0] = x[0] + x[5]
-		x[15] = ((x[15] ^ x[0]) << 16) | ((x[15] ^ x[0]) >> (32 - 16))
-		x[10] = x[10] + x[15]
-		x[5] = ((x[5] ^ x[10]) << 12) | ((x[5] ^ x[10]) >> (32 - 12))
-		x[0] = x[0] + x[5]
-		x[15] = ((x[15] ^ x[0]) << 8) | ((x[15] ^ x[0]) >> (32 - 8))
-		x[10] = x[10] + x[15]
-		x[5] = ((x[5] ^ x[10]) << 7) | ((x[5] ^ x[10]) >> (32 - 7))
-
-		x[1] = x[1] + x[6]
-		x[12] = ((x[12] ^ x[1]) << 16) | ((x[12] ^ x[1]) >> (32 - 16))
-		x[11] = x[11] + x[12]
-		x[6] = ((x[6] ^ x[11]) << 12) | ((x[6] ^ x[11]) >> (32 - 12))
-		x[1] = x[1] + x[6]
-		x[12] = ((x[12] ^ x[1]) << 8) | ((x[12] ^ x[1]) >> (32 - 8))
-		x[11] = x[11] + x[12]
-		x[6] = ((x[6] ^ x[11]) << 7) | ((x[6] ^ x[11]) >> (32 - 7))
-
-		x[2] = x[2] + x[7]
-		x[13] = ((x[13] ^ x[2]) << 16) | ((x[13] ^ x[2]) >> (32 - 16))
-		x[8] = x[8] + x[13]
-		x[7] = ((x[7] ^ x[8]) << 12) | ((x[7] ^ x[8]) >> (32 - 12))
-		x[2] = x[2] + x[7]
-		x[13] = ((x[13] ^ x[2]) << 8) | ((x[13] ^ x[2]) >> (32 - 8))
-		x[8] = x[8] + x[13]
-		x[7] = ((x[7] ^ x[8]) << 7) | ((x[7] ^ x[8]) >> (32 - 7))
-
-		x[3] = x[3] + x[4]
-		x[14] = ((x[14] ^ x[3]) << 16) | ((x[14] ^ x[3]) >> (32 - 16))
-		x[9] = x[9] + x[14]
-		x[4] = ((x[4] ^ x[9]) << 12) | ((x[4] ^ x[9]) >> (32 - 12))
-		x[3] = x[3] + x[4]
-		x[14] = ((x[14] ^ x[3]) << 8) | ((x[14] ^ x[3]) >> (32 - 8))
-		x[9] = x[9] + x[14]
-		x[4] = ((x[4] ^ x[9]) << 7) | ((x[4] ^ x[9]) >> (32 - 7))
-	}
-	for i := 0; i < 16; i++ {
-		x[i] += c.input[i]
-		binary.LittleEndian.PutUint32(c.output[i*4:], x[i])
-	}
-
-	// Update block counter
-	ctr := (uint64(c.input[13])<<32 | uint64(c.input[12])) + 1
-	if ctr == 0 {
-		c.eof = true
-	}
-	c.input[12] = uint32(ctr)
-	c.input[13] = uint32(ctr >> 32)
+// Panics if the cipher may not buffer keystream (NewUnbuffered).
+func (c *Cipher) mustBuffer() {
+	if c.unbuf {
+		panic(ErrUnaligned)
+	}
+}
 
+// Fills the output field with the next block and resets nextByte.
+func (c *Cipher) next() error {
+	c.mustBuffer()
+	if _, err := c.nextBlocks(c.output[:], 1); err != nil {
+		return err
+	}
 	c.nextByte = 0
 	return nil
 }
 
+// Writes up to n keystream blocks into dst and returns the number
+// written, which is short only when the keystream is exhausted. Blocks
+// are computed from a local copy of the state, four at a time when a
+// vector core is available, and the counter is stored once at the end.
+func (c *Cipher) nextBlocks(dst []byte, n int) (int, error) {
+	if c.eof || c.zeroed {
+		return 0, ErrExhausted
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	// Stop at the end of the keystream, if it has one
+	var err error
+	ctr := c.counter()
+	switch {
+	case c.wrap:
+	case c.ietf:
+		if left := 1<<32 - ctr; uint64(n) > left {
+			n = int(left)
+			err = ErrExhausted
+		}
+	case ctr != 0 && uint64(n) > -ctr:
+		n = int(-ctr)
+		err = ErrExhausted
+	}
+
+	x := c.input
+	for i := 0; i < n; {
+		// The vector core cannot carry into the counter's high word
+		if n-i >= 4 && x[12] <= 0xfffffffc && !c.salsa &&
+			blocks4((*[256]byte)(dst[i*BlockSize:]), &x, c.rounds) {
+			x[12] += 4
+			i += 4
+		} else {
+			if c.salsa {
+				salsaBlock((*[BlockSize]byte)(dst[i*BlockSize:]), &x, c.rounds)
+			} else {
+				Block((*[BlockSize]byte)(dst[i*BlockSize:]), &x, c.rounds)
+			}
+			x[12]++
+			i++
+		}
+		if x[12] == 0 && !c.ietf {
+			x[13]++
+		}
+	}
+	c.input[12] = x[12]
+	c.input[13] = x[13]
+	c.eof = !c.wrap && c.counter() == 0
+	return n, err
+}
+
+// Block computes the ChaCha keystream block for a raw 16-word state: it
+// applies the given number of rounds, which must be even, and adds the
+// input back in. Nothing is advanced, not even the counter in state. It
+// is intended for testing and verification against other
+// implementations; use a Cipher for encryption.
+func Block(out *[BlockSize]byte, in *[16]uint32, rounds int) {
+	// The state is held in local variables so that it may live in
+	// registers.
+	x0, x1, x2, x3 := in[0], in[1], in[2], in[3]
+	x4, x5, x6, x7 := in[4], in[5], in[6], in[7]
+	x8, x9, x10, x11 := in[8], in[9], in[10], in[11]
+	x12, x13, x14, x15 := in[12], in[13], in[14], in[15]
+
+	for i := rounds; i > 0; i -= 2 {
+		x0, x4, x8, x12 = quarterRound(x0, x4, x8, x12)
+		x1, x5, x9, x13 = quarterRound(x1, x5, x9, x13)
+		x2, x6, x10, x14 = quarterRound(x2, x6, x10, x14)
+		x3, x7, x11, x15 = quarterRound(x3, x7, x11, x15)
+		x0, x5, x10, x15 = quarterRound(x0, x5, x10, x15)
+		x1, x6, x11, x12 = quarterRound(x1, x6, x11, x12)
+		x2, x7, x8, x13 = quarterRound(x2, x7, x8, x13)
+		x3, x4, x9, x14 = quarterRound(x3, x4, x9, x14)
+	}
+
+	binary.LittleEndian.PutUint32(out[0:], x0+in[0])
+	binary.LittleEndian.PutUint32(out[4:], x1+in[1])
+	binary.LittleEndian.PutUint32(out[8:], x2+in[2])
+	binary.LittleEndian.PutUint32(out[12:], x3+in[3])
+	binary.LittleEndian.PutUint32(out[16:], x4+in[4])
+	binary.LittleEndian.PutUint32(out[20:], x5+in[5])
+	binary.LittleEndian.PutUint32(out[24:], x6+in[6])
+	binary.LittleEndian.PutUint32(out[28:], x7+in[7])
+	binary.LittleEndian.PutUint32(out[32:], x8+in[8])
+	binary.LittleEndian.PutUint32(out[36:], x9+in[9])
+	binary.LittleEndian.PutUint32(out[40:], x10+in[10])
+	binary.LittleEndian.PutUint32(out[44:], x11+in[11])
+	binary.LittleEndian.PutUint32(out[48:], x12+in[12])
+	binary.LittleEndian.PutUint32(out[52:], x13+in[13])
+	binary.LittleEndian.PutUint32(out[56:], x14+in[14])
+	binary.LittleEndian.PutUint32(out[60:], x15+in[15])
+}
+
+func quarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	a += b
+	d = bits.RotateLeft32(d^a, 16)
+	c += d
+	b = bits.RotateLeft32(b^c, 12)
+	a += b
+	d = bits.RotateLeft32(d^a, 8)
+	c += d
+	b = bits.RotateLeft32(b^c, 7)
+	return a, b, c, d
+}
+
 // Seek sets the cipher's internal stream position to the nth 64-byte
 // block. For example, Seek(0) sets the cipher back to its initial
-// state.
+// state. Seek never fails: for IETF ciphers n is taken modulo 2^32,
+// silently wrapping out-of-range positions. Use SeekErr to detect those.
 func (c *Cipher) Seek(n uint64) {
-	c.input[12] = uint32(n)
-	c.input[13] = uint32(n >> 32)
+	if c.unbuf {
+		c.SetCounter(n)
+		return
+	}
+	c.setCounter(n)
+	c.eof = false
+	c.next() // fails only when zeroized
+}
+
+// SeekBlock is like Seek, but returns the block that held the stream
+// position before seeking: Tell() / 64. Passing that value back to Seek
+// or SeekBlock returns to the start of that block, which is the previous
+// position exactly when it was on a block boundary. To restore a position
+// within a block, save Tell and use SeekByte instead. Note that this
+// generally differs from Counter, which runs one block ahead while
+// keystream is buffered.
+func (c *Cipher) SeekBlock(n uint64) uint64 {
+	prev := c.Tell() / uint64(len(c.output))
+	c.Seek(n)
+	return prev
+}
+
+// SeekErr is like Seek, but returns ErrExhausted if block n lies beyond the
+// end of the keystream, which is only possible for IETF ciphers. On error
+// the cipher is left unchanged.
+func (c *Cipher) SeekErr(n uint64) error {
+	if c.ietf && !c.wrap && n > math.MaxUint32 {
+		return ErrExhausted
+	}
+	if c.unbuf {
+		c.SetCounter(n)
+		return nil
+	}
+	c.setCounter(n)
 	c.eof = false
-	c.next() // always succeeds
+	return c.next()
+}
+
+// SeekByte sets the cipher's internal stream position to the given byte
+// offset, so that the next output byte is the one at that offset. Like
+// Seek, the block index (offset / 64) is taken modulo 2^32 for IETF
+// ciphers.
+func (c *Cipher) SeekByte(offset uint64) {
+	partial := int(offset % uint64(len(c.output)))
+	if partial > 0 {
+		c.mustBuffer()
+	}
+	c.Seek(offset / uint64(len(c.output)))
+	if partial > 0 && c.nextByte == 0 {
+		c.nextByte = partial
+	}
+}
+
+// Skip advances the stream position by n bytes, as if n bytes of
+// keystream were read and discarded. Whole blocks are skipped without
+// being generated. Skipping to or past the end of the keystream leaves
+// the cipher exhausted.
+func (c *Cipher) Skip(n uint64) {
+	if n%uint64(len(c.output)) != 0 {
+		c.mustBuffer()
+	}
+	buffered := uint64(len(c.output) - c.nextByte)
+	if n <= buffered {
+		c.nextByte += int(n)
+		return
+	}
+	n -= buffered
+	blocks := n / uint64(len(c.output))
+
+	// Compare in blocks, since Remaining saturates. For the original
+	// layout, zero blocks left means all 2^64 of them.
+	if !c.wrap {
+		left := -c.counter()
+		if c.ietf {
+			left = 1<<32 - c.counter()
+		}
+		if c.eof || left != 0 && blocks >= left {
+			c.setCounter(0)
+			c.nextByte = len(c.output)
+			c.eof = true
+			return
+		}
+	}
+
+	c.setCounter(c.counter() + blocks)
+	c.nextByte = len(c.output)
+	if partial := int(n % uint64(len(c.output))); partial > 0 {
+		if c.next() == nil { // fails only when zeroized
+			c.nextByte = partial
+		}
+	}
+}
+
+// SeekRelative moves the stream position by delta bytes from Tell, forward
+// or backward, like io.SeekCurrent. Moving before the start of the
+// keystream, or past its end, returns an error and leaves the cipher
+// unchanged. Moving exactly to the end leaves the cipher exhausted.
+// Backward moves go through Tell, and so fail with an error past the
+// first 2^64 bytes of a non-IETF keystream. For an unbuffered cipher, a
+// delta that is not a whole number of blocks returns ErrUnaligned.
+func (c *Cipher) SeekRelative(delta int64) error {
+	if delta >= 0 {
+		if uint64(delta) > c.Remaining() {
+			return ErrExhausted
+		}
+		if c.unbuf && delta%int64(len(c.output)) != 0 {
+			return ErrUnaligned
+		}
+		c.Skip(uint64(delta))
+		return nil
+	}
+	if c.pastTell() {
+		return errSeekRange
+	}
+	pos := c.Tell()
+	back := uint64(-delta)
+	if back > pos {
+		return errSeekNegative
+	}
+	return c.seekOffset(pos - back)
+}
+
+// SeekTo sets the stream position following io.Seeker semantics, where
+// the end is the exhaustion boundary, returning the new offset. Seeks
+// before the start or past the end of the keystream return an error and
+// leave the cipher unchanged, as do results that do not fit in an int64.
+// io.SeekCurrent fails the same way once the position is past 2^64 bytes,
+// where Tell wraps. Since a 64-bit counter puts the end at 2^70 bytes, io.SeekEnd is only
+// supported by IETF ciphers. For an unbuffered cipher, offsets within a
+// block return ErrUnaligned. Cipher already has a Seek method, by block,
+// so use ReadSeeker where an io.ReadSeeker is needed.
+func (c *Cipher) SeekTo(offset int64, whence int) (int64, error) {
+	var pos uint64
+	switch whence {
+	case io.SeekStart:
+		if offset < 0 {
+			return 0, errSeekNegative
+		}
+		pos = uint64(offset)
+	case io.SeekCurrent:
+		if c.pastTell() {
+			return 0, errSeekRange
+		}
+		cur := c.Tell()
+		if offset < 0 && uint64(-offset) > cur {
+			return 0, errSeekNegative
+		}
+		pos = cur + uint64(offset)
+		if offset > 0 && pos < cur {
+			return 0, errSeekRange
+		}
+	case io.SeekEnd:
+		if !c.ietf {
+			return 0, errSeekRange
+		}
+		if offset > 0 {
+			return 0, ErrExhausted
+		}
+		if uint64(-offset) > 1<<38 {
+			return 0, errSeekNegative
+		}
+		pos = 1<<38 - uint64(-offset)
+	default:
+		return 0, errWhence
+	}
+	if pos > math.MaxInt64 {
+		return 0, errSeekRange
+	}
+	if c.ietf && !c.wrap && pos > 1<<38 {
+		return 0, ErrExhausted
+	}
+	if err := c.seekOffset(pos); err != nil {
+		return 0, err
+	}
+	return int64(c.Tell()), nil
+}
+
+// ReadSeeker returns an io.ReadSeeker over the cipher's keystream, whose
+// Seek method is SeekTo. It shares the cipher's state.
+func (c *Cipher) ReadSeeker() io.ReadSeeker {
+	return readSeeker{c}
+}
+
+type readSeeker struct {
+	c *Cipher
+}
+
+func (r readSeeker) Read(p []byte) (int, error) {
+	return r.c.Read(p)
+}
+
+func (r readSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.c.SeekTo(offset, whence)
+}
+
+// ThrottledReader returns an io.Reader over the cipher's keystream that
+// produces at most bytesPerSec bytes per second on average, sleeping as
+// needed rather than spinning. Given room for at least one block, each
+// Read fills a multiple of 64 bytes, at most one second's worth but never
+// less than a block. A smaller p is filled completely. It shares the
+// cipher's state. It panics if bytesPerSec is not positive.
+func (c *Cipher) ThrottledReader(bytesPerSec int) io.Reader {
+	if bytesPerSec <= 0 {
+		panic("chacha: ThrottledReader rate must be positive")
+	}
+	return &throttledReader{c: c, rate: bytesPerSec}
+}
+
+type throttledReader struct {
+	c    *Cipher
+	rate int
+	due  time.Time // when the bytes produced so far are paid for
 }
 
-// Read implements io.Reader.Read(). After 2^70 bytes of output the
-// keystream will be exhausted and this function will return the io.EOF
-// error. There are no other error conditions.
+func (t *throttledReader) Read(p []byte) (int, error) {
+	limit := t.rate &^ (BlockSize - 1)
+	if limit < BlockSize {
+		limit = BlockSize
+	}
+	if len(p) > limit {
+		p = p[:limit]
+	} else if len(p) > BlockSize {
+		p = p[:len(p)&^(BlockSize-1)]
+	}
+
+	// Unused time is not banked, so the rate never bursts above the cap
+	if now := time.Now(); t.due.Before(now) {
+		t.due = now
+	}
+	n, err := t.c.Read(p)
+	t.due = t.due.Add(time.Duration(n) * time.Second / time.Duration(t.rate))
+	time.Sleep(time.Until(t.due))
+	return n, err
+}
+
+// Moves to a byte offset no further than the end of the keystream. An
+// unbuffered cipher is checked for alignment before anything changes.
+func (c *Cipher) seekOffset(pos uint64) error {
+	if c.unbuf && pos%uint64(len(c.output)) != 0 {
+		return ErrUnaligned
+	}
+	c.SetCounter(0)
+	c.Skip(pos)
+	return nil
+}
+
+// SetCounter sets the block counter to n without generating a block, so
+// the next output byte is the first byte of block n. Unlike Seek, any
+// buffered keystream from the previous position is discarded rather than
+// regenerated. Like Seek, n is taken modulo 2^32 for IETF ciphers.
+func (c *Cipher) SetCounter(n uint64) {
+	c.setCounter(n)
+	c.nextByte = len(c.output)
+	c.eof = false
+}
+
+// Counter returns the block counter: the index of the next block to be
+// generated. While keystream remains buffered from the previous block,
+// the stream position (see Tell) lags behind Counter() * 64.
+func (c *Cipher) Counter() uint64 {
+	return c.counter()
+}
+
+// State returns a copy of the cipher's 16-word input state: constants,
+// key, block counter, and nonce, in the layout the cipher uses, as would
+// be passed to Block. Like Counter, the counter words are those of the
+// next block to be generated. The state includes the key, so it must be
+// protected like one. See NewFromState for the reverse.
+func (c *Cipher) State() [16]uint32 {
+	return c.input
+}
+
+// Rounds returns the number of rounds the cipher was created with.
+func (c *Cipher) Rounds() int {
+	return c.rounds
+}
+
+// Tell returns the cipher's current byte offset into the keystream, the
+// position of the next output byte. Offsets are reported modulo 2^64,
+// which only matters past the first 2^64 bytes of a non-IETF keystream.
+func (c *Cipher) Tell() uint64 {
+	// The counter has already moved past the block held in output
+	n := c.counter()
+	if c.eof && c.ietf {
+		n = 1 << 32
+	}
+	pos := n*uint64(len(c.output)) - uint64(len(c.output)-c.nextByte)
+	if c.ietf && !c.eof {
+		pos &= 1<<38 - 1 // the buffered block may precede a wrap
+	}
+	return pos
+}
+
+// Reports whether the position is at or past 2^64 bytes, where Tell
+// wraps, which only a non-IETF keystream can reach.
+func (c *Cipher) pastTell() bool {
+	if c.ietf {
+		return false
+	}
+	n := c.counter()
+	return c.eof || n > 1<<58 || n == 1<<58 && c.nextByte == len(c.output)
+}
+
+// Position is a saved stream position, a lightweight alternative to
+// MarshalBinary for returning to a point in the same keystream. It
+// records only the position, not the key or nonce.
+type Position struct {
+	counter  uint64
+	nextByte int
+	eof      bool
+}
+
+// Position returns the current stream position, for use with Restore.
+func (c *Cipher) Position() Position {
+	return Position{c.counter(), c.nextByte, c.eof}
+}
+
+// Restore returns the cipher to a position saved by Position, such that
+// it produces the same keystream from there as it did then. The buffered
+// block is regenerated if necessary.
+func (c *Cipher) Restore(p Position) {
+	if p.counter == c.counter() && c.nextByte < len(c.output) {
+		// Still holding the same block
+		c.nextByte = p.nextByte
+		c.eof = p.eof
+		return
+	}
+	c.setCounter(p.counter)
+	c.restore(p.nextByte, p.eof)
+}
+
+// Remaining returns the number of keystream bytes left before the
+// keystream is exhausted. Since a 64-bit counter allows for 2^70 bytes,
+// the result saturates at the maximum uint64. Once the final block has
+// been generated, only its unread bytes remain.
+func (c *Cipher) Remaining() uint64 {
+	buffered := uint64(len(c.output) - c.nextByte)
+	if c.zeroed {
+		return 0
+	}
+	if c.eof {
+		return buffered
+	}
+	if c.wrap {
+		return math.MaxUint64
+	}
+
+	var blocks uint64
+	if c.ietf {
+		blocks = 1<<32 - c.counter()
+	} else {
+		blocks = -c.counter() // 2^64 - counter
+		if blocks == 0 || blocks > (math.MaxUint64-buffered)/64 {
+			return math.MaxUint64
+		}
+	}
+	return blocks*uint64(len(c.output)) + buffered
+}
+
+// Exhausted reports whether the keystream has run out, such that any
+// further output would fail with io.EOF or ErrExhausted. It is equivalent
+// to Remaining() == 0. Bytes of the final block that are still buffered
+// do not count as exhausted.
+func (c *Cipher) Exhausted() bool {
+	return (c.eof || c.zeroed) && c.nextByte == len(c.output)
+}
+
+// Returns the block counter, which indexes the block after the one held
+// in output.
+func (c *Cipher) counter() uint64 {
+	if c.ietf {
+		return uint64(c.input[12])
+	}
+	return uint64(c.input[13])<<32 | uint64(c.input[12])
+}
+
+// Sets the block counter, truncating it to 32 bits for IETF ciphers.
+func (c *Cipher) setCounter(n uint64) {
+	c.input[12] = uint32(n)
+	if !c.ietf {
+		c.input[13] = uint32(n >> 32)
+	}
+}
+
+// SetWrapOnOverflow controls what happens when the block counter
+// overflows. By default the keystream is then exhausted, but with wrap
+// enabled the cipher continues from block 0, so the keystream repeats
+// from the beginning. Reusing keystream this way destroys the security of
+// the cipher for any data encrypted with both passes. Only enable it to
+// match a system that depends on a cyclic keystream. Enabling wrap on an
+// exhausted cipher resumes it at block 0.
+func (c *Cipher) SetWrapOnOverflow(wrap bool) {
+	c.wrap = wrap
+	if wrap {
+		c.eof = false
+	}
+}
+
+// Reset rewinds the cipher to the start of its keystream, as if freshly
+// constructed. The key and nonce are retained, so the same keystream is
+// produced again. It is equivalent to Seek(0).
+func (c *Cipher) Reset() {
+	c.Seek(0)
+}
+
+// Clone returns an independent copy of the cipher at its current stream
+// position. The clone and the original each continue producing the same
+// keystream without affecting one another.
+func (c *Cipher) Clone() *Cipher {
+	clone := *c
+	return &clone
+}
+
+// Substream returns a new cipher with the same key and rounds, positioned
+// at the start of its keystream, whose nonce is the cipher's nonce with
+// id XORed, as a little endian integer, into its last 8 bytes. Distinct
+// ids give non-overlapping keystreams, so one key can drive many
+// independent channels. The cipher itself is not affected.
+//
+// Substream id of nonce N is exactly the keystream of nonce N^id, and id
+// 0 is the cipher's own keystream. So when using substreams, the key must
+// not also be used with other nonces, nor the parent stream used
+// alongside substream 0.
+func (c *Cipher) Substream(id uint64) *Cipher {
+	sub := *c
+	sub.input[14] ^= uint32(id)
+	sub.input[15] ^= uint32(id >> 32)
+	sub.SetCounter(0)
+	return &sub
+}
+
+// Zeroize overwrites the key, nonce, and buffered keystream with zeros
+// and marks the keystream exhausted, so the cipher cannot be accidentally
+// reused. It stays exhausted even after Reset, Seek, SetCounter, and the
+// like, and only Rekey makes it usable again. This only clears the
+// cipher's own fields: copies made by the garbage collector, Clone, or
+// MarshalBinary are unaffected.
+func (c *Cipher) Zeroize() {
+	c.input = [16]uint32{}
+	c.output = [BlockSize]byte{}
+	c.nextByte = len(c.output)
+	c.eof = true
+	c.zeroed = true
+}
+
+// Read implements io.Reader.Read(). After 2^70 bytes of output (2^38
+// bytes for NewIETF and NewCounter32 ciphers) the keystream is exhausted
+// and Read returns io.EOF, its only error. A cipher set to wrap on
+// overflow never returns it. A cipher from NewUnbuffered panics with
+// ErrUnaligned, rather than returning an error, if len(p) is not a
+// multiple of 64.
+//
+// The EOF is reported lazily: a Read that ends exactly on the last byte
+// of keystream returns len(p) and a nil error, and only the following
+// Read returns 0 and io.EOF. A Read that asks for more than remains
+// returns the remainder along with io.EOF. An empty Read always returns
+// 0 and a nil error, and does not change the cipher.
 func (c *Cipher) Read(p []byte) (int, error) {
-	n := 0
-	for ; n < len(p); n++ {
-		if c.nextByte >= len(c.output) {
-			if err := c.next(); err != nil {
-				return n, io.EOF
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := c.keystream(p)
+	if err != nil {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// ReadProgress is like Read, with identical results, but fills p in
+// batches of 16 KiB and calls cb after each with the total number of
+// bytes read so far. It is intended for reporting progress on very large
+// reads. The callback is not called for an empty read.
+func (c *Cipher) ReadProgress(p []byte, cb func(done int)) (int, error) {
+	const batch = 16 << 10
+	var total int
+	for total < len(p) {
+		end := len(p)
+		if end-total > batch {
+			end = total + batch
+		}
+		n, err := c.keystream(p[total:end])
+		total += n
+		if n > 0 {
+			cb(total)
+		}
+		if err != nil {
+			return total, io.EOF
+		}
+	}
+	return total, nil
+}
+
+// ReadByte implements io.ByteReader, returning the next keystream byte.
+// Like Read, it returns io.EOF once the keystream is exhausted.
+func (c *Cipher) ReadByte() (byte, error) {
+	if c.nextByte == len(c.output) {
+		if err := c.next(); err != nil {
+			return 0, io.EOF
+		}
+	}
+	b := c.output[c.nextByte]
+	c.nextByte++
+	return b, nil
+}
+
+// Blocks returns the next n whole keystream blocks in a newly allocated
+// slice of n*64 bytes, advancing the stream by as much. The result always
+// starts on a block boundary: when the position is partway into a block,
+// the rest of that block is discarded. If the keystream runs out, the
+// slice is short, holding only the blocks that remained, and the cipher
+// is exhausted.
+func (c *Cipher) Blocks(n int) []byte {
+	out := make([]byte, n*BlockSize)
+	var done int
+	if c.nextByte == 0 && n > 0 {
+		copy(out, c.output[:])
+		done = 1
+	}
+	c.nextByte = len(c.output)
+	m, _ := c.nextBlocks(out[done*BlockSize:], n-done)
+	return out[:(done+m)*BlockSize]
+}
+
+// FillRing writes whole keystream blocks into the ring buffer buf,
+// starting at index start and wrapping around at the end, bypassing the
+// cipher's internal buffer. It writes len(buf)/64 blocks, fewer only if
+// the keystream runs out, and returns the number of bytes written. Like
+// Blocks, it begins on a block boundary, discarding the rest of a
+// partially read block. It panics if start is not an index into buf.
+func (c *Cipher) FillRing(buf []byte, start int) int {
+	if start < 0 || start >= len(buf) {
+		if len(buf) == 0 {
+			return 0
+		}
+		panic("chacha: ring start out of range")
+	}
+	blocks := len(buf) / BlockSize
+	pos := start
+	var n int
+
+	// Copies one block into the ring, split across the end if need be
+	put := func(block *[BlockSize]byte) {
+		m := copy(buf[pos:], block[:])
+		copy(buf, block[m:])
+		pos = (pos + BlockSize) % len(buf)
+		n++
+	}
+	if c.nextByte == 0 && blocks > 0 {
+		put(&c.output)
+	}
+	c.nextByte = len(c.output)
+
+	for n < blocks {
+		if run := (len(buf) - pos) / BlockSize; run > 0 {
+			if run > blocks-n {
+				run = blocks - n
+			}
+			m, err := c.nextBlocks(buf[pos:], run)
+			n += m
+			pos = (pos + m*BlockSize) % len(buf)
+			if err != nil {
+				break
 			}
-			c.nextByte = 0
+			continue
+		}
+		var block [BlockSize]byte
+		if _, err := c.nextBlocks(block[:], 1); err != nil {
+			break
 		}
-		p[n] = c.output[c.nextByte]
-		c.nextByte++
+		put(&block)
+	}
+	return n * BlockSize
+}
+
+// KeyStream fills dst with raw keystream, advancing the stream exactly as
+// XORKeyStream would. It will panic with ErrExhausted when the keystream
+// has been exhausted.
+func (c *Cipher) KeyStream(dst []byte) {
+	if _, err := c.keystream(dst); err != nil {
+		panic(err)
+	}
+}
+
+// WriteTo implements io.WriterTo, writing keystream to w until either the
+// keystream is exhausted or w returns an error. Since exhaustion is the
+// expected end of the stream, it is not reported as an error.
+func (c *Cipher) WriteTo(w io.Writer) (int64, error) {
+	return c.WriteToContext(context.Background(), w)
+}
+
+// Drain writes every remaining byte of keystream to w, returning the
+// total and a nil error once the keystream is exhausted, or the first
+// error from w. It is like WriteTo, except that with SetWrapOnOverflow
+// enabled it still stops at the end of the counter range, rather than
+// continuing forever, and leaves the cipher at block 0.
+func (c *Cipher) Drain(w io.Writer) (int64, error) {
+	if c.wrap {
+		c.wrap = false
+		defer c.SetWrapOnOverflow(true)
+	}
+	return c.WriteTo(w)
+}
+
+// WriteToContext is like WriteTo, but also stops when ctx is cancelled,
+// returning ctx.Err() and the number of bytes written until then. The
+// context is checked between batches of 16 KiB.
+func (c *Cipher) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	var total int64
+	buf := make([]byte, 16<<10)
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		n, err := c.keystream(buf)
+		if n > 0 {
+			m, werr := w.Write(buf[:n])
+			total += int64(m)
+			if werr != nil {
+				return total, werr
+			}
+			if m != n {
+				return total, io.ErrShortWrite
+			}
+		}
+		if err != nil {
+			return total, nil
+		}
+	}
+}
+
+// Copies keystream into p, stopping early if the keystream is exhausted.
+func (c *Cipher) keystream(p []byte) (int, error) {
+	if len(p)%len(c.output) != 0 {
+		c.mustBuffer()
+	}
+	n := copy(p, c.output[c.nextByte:])
+	c.nextByte += n
+
+	// Generate whole blocks directly into p
+	if blocks := (len(p) - n) / len(c.output); blocks > 0 {
+		m, err := c.nextBlocks(p[n:], blocks)
+		n += m * len(c.output)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		if err := c.next(); err != nil {
+			return n, err
+		}
+		c.nextByte = copy(p[n:], c.output[:])
+		n += c.nextByte
 	}
 	return n, nil
 }
 
-// XORKeyStream implements crypto/cipher.Cipher. It will panic when the
-// keystream has been exhausted.
+// XORKeyStream implements crypto/cipher.Cipher. Like other Stream
+// implementations, it will panic if len(dst) < len(src), or if dst and
+// src overlap other than exactly. If src is longer than the remaining
+// keystream, it panics with ErrAlreadyExhausted when none remained on
+// entry, and with ErrExhausted otherwise. Either way it panics before
+// writing to dst or advancing the cipher, so a partial operation never
+// happens.
 func (c *Cipher) XORKeyStream(dst, src []byte) {
-	for i := 0; i < len(dst); i++ {
-		if c.nextByte >= len(c.output) {
-			if err := c.next(); err != nil {
-				panic(err)
+	if len(dst) < len(src) {
+		panic("chacha: output smaller than input")
+	}
+	dst = dst[:len(src)]
+	if inexactOverlap(dst, src) {
+		panic("chacha: invalid buffer overlap")
+	}
+	if len(src) > len(c.output)-c.nextByte && uint64(len(src)) > c.Remaining() {
+		if c.Exhausted() {
+			panic(ErrAlreadyExhausted)
+		}
+		panic(ErrExhausted)
+	}
+	if c.unbuf {
+		c.xorUnbuffered(dst, src)
+		return
+	}
+
+	// Use up buffered keystream first, which for small inputs may be all
+	// that is needed
+	buffered := c.output[c.nextByte:]
+	n := subtle.XORBytes(dst, src, buffered)
+	c.nextByte += n
+	if len(src) <= len(buffered) {
+		return
+	}
+	dst = dst[n:]
+	src = src[n:]
+
+	// Whole blocks go through a scratch buffer on the stack, which allows
+	// dst and src to be the same buffer
+	var buf [8 * BlockSize]byte
+	for len(src) >= BlockSize {
+		blocks := len(src) / BlockSize
+		if blocks > len(buf)/BlockSize {
+			blocks = len(buf) / BlockSize
+		}
+		m, err := c.nextBlocks(buf[:], blocks)
+		n := subtle.XORBytes(dst, src, buf[:m*BlockSize])
+		dst = dst[n:]
+		src = src[n:]
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if len(src) > 0 {
+		if err := c.next(); err != nil {
+			panic(err)
+		}
+		c.nextByte = subtle.XORBytes(dst, src, c.output[:])
+	}
+}
+
+// XORKeyStream for unbuffered ciphers, where nothing is ever buffered.
+// When dst and src are distinct, keystream is generated straight into dst
+// and the scratch buffer is skipped.
+func (c *Cipher) xorUnbuffered(dst, src []byte) {
+	if len(src)%len(c.output) != 0 {
+		panic(ErrUnaligned)
+	}
+	if len(src) > 0 && &dst[0] != &src[0] {
+		m, err := c.nextBlocks(dst, len(dst)/BlockSize)
+		subtle.XORBytes(dst, dst[:m*BlockSize], src)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	var buf [8 * BlockSize]byte
+	for len(src) > 0 {
+		chunk := buf[:]
+		if len(src) < len(chunk) {
+			chunk = chunk[:len(src)]
+		}
+		m, err := c.nextBlocks(chunk, len(chunk)/BlockSize)
+		n := subtle.XORBytes(dst, src, chunk[:m*BlockSize])
+		dst = dst[n:]
+		src = src[n:]
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// XORAt is like XORKeyStream, but XORs with the keystream starting at the
+// given byte offset rather than at the current position, which is left
+// unchanged. It allows random access to data encrypted as a single
+// stream. It panics with ErrExhausted if the range extends past the end
+// of the keystream.
+func (c *Cipher) XORAt(dst, src []byte, offset uint64) {
+	t := *c
+	if err := t.SeekErr(offset / uint64(len(t.output))); err != nil {
+		panic(err)
+	}
+	if partial := int(offset % uint64(len(t.output))); partial > 0 {
+		t.mustBuffer()
+		t.nextByte = partial
+	}
+	t.XORKeyStream(dst, src)
+}
+
+// XORInPlace XORs the keystream into buf, encrypting or decrypting it in
+// place. It is equivalent to XORKeyStream(buf, buf).
+func (c *Cipher) XORInPlace(buf []byte) {
+	c.XORKeyStream(buf, buf)
+}
+
+// CombineXOR fills dst with the XOR of the next len(dst) keystream bytes
+// of a and b, advancing both. It panics with ErrExhausted, before
+// advancing either, if either keystream has fewer than len(dst) bytes
+// remaining. The ciphers must be distinct.
+func CombineXOR(dst []byte, a, b *Cipher) {
+	if a.Remaining() < uint64(len(dst)) || b.Remaining() < uint64(len(dst)) {
+		panic(ErrExhausted)
+	}
+	a.KeyStream(dst)
+	b.XORKeyStream(dst, dst)
+}
+
+// StreamsEqual reports whether a and b produce the same next n bytes of
+// keystream, for checking that two builds or cores agree. It reads from
+// clones, leaving a and b unchanged. If either keystream has fewer than n
+// bytes left, the streams are equal only if both end at the same point.
+// The comparison is not constant time, so it is not for secret data.
+func StreamsEqual(a, b *Cipher, n int) bool {
+	a, b = a.Clone(), b.Clone()
+	// Buffering does not change the keystream, and lets the clones of
+	// unbuffered ciphers read partial blocks
+	a.unbuf, b.unbuf = false, false
+	var bufA, bufB [4096]byte
+	for n > 0 {
+		size := len(bufA)
+		if size > n {
+			size = n
+		}
+		na, _ := io.ReadFull(a, bufA[:size])
+		nb, _ := io.ReadFull(b, bufB[:size])
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false
+		}
+		if na < size {
+			return true
+		}
+		n -= size
+	}
+	return true
+}
+
+// ChaCha20 XORs src with the RFC 8439 ChaCha20 keystream for the given
+// key and 12-byte nonce, starting at block counter, into dst, with no
+// cipher to manage. It panics with ErrNonceSize if the nonce is not
+// exactly 12 bytes, and otherwise like XORKeyStream, including with
+// ErrExhausted if the input runs past block 2^32-1.
+func ChaCha20(key [32]byte, nonce []byte, counter uint32, dst, src []byte) {
+	if len(nonce) != NonceSizeIETF {
+		panic(ErrNonceSize)
+	}
+	var c Cipher
+	defer c.Zeroize()
+	c.init(key[:], 20)
+	c.input[12] = counter
+	c.input[13] = binary.LittleEndian.Uint32(nonce[0:])
+	c.input[14] = binary.LittleEndian.Uint32(nonce[4:])
+	c.input[15] = binary.LittleEndian.Uint32(nonce[8:])
+	c.ietf = true
+	c.XORKeyStream(dst, src)
+}
+
+// Reader returns a reader that XORs the keystream into everything read
+// from r, decrypting or encrypting it.
+func (c *Cipher) Reader(r io.Reader) io.Reader {
+	return cipher.StreamReader{S: c, R: r}
+}
+
+// Writer returns a writer that XORs the keystream into everything written
+// before passing it on to w. Its Close method does nothing, and in
+// particular does not close w.
+func (c *Cipher) Writer(w io.Writer) io.WriteCloser {
+	return streamWriter{cipher.StreamWriter{S: c, W: w}}
+}
+
+// Like cipher.StreamWriter, but Close does not close the underlying writer.
+type streamWriter struct {
+	cipher.StreamWriter
+}
+
+func (w streamWriter) Close() error {
+	return nil
+}
+
+// WrapWriter returns a writer that encrypts everything written with the
+// keystream and forwards the ciphertext to w. Unlike Writer, it encrypts
+// through pooled scratch buffers and so does not allocate per Write. On a
+// short write to w, it returns the number of bytes actually written, and
+// the keystream advances by exactly that much.
+func (c *Cipher) WrapWriter(w io.Writer) io.Writer {
+	return &wrappedWriter{c, w}
+}
+
+var scratchPool = sync.Pool{
+	New: func() any { return new([16 * 1024]byte) },
+}
+
+type wrappedWriter struct {
+	c *Cipher
+	w io.Writer
+}
+
+func (w *wrappedWriter) Write(p []byte) (int, error) {
+	buf := scratchPool.Get().(*[16 * 1024]byte)
+	defer scratchPool.Put(buf)
+
+	var n int
+	for len(p) > 0 {
+		chunk := buf[:copy(buf[:], p)]
+		m, err := w.encrypt(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// ReadFrom implements io.ReaderFrom, so that io.Copy reads straight into
+// the scratch buffer and encrypts it in place, without an intermediate
+// copy.
+func (w *wrappedWriter) ReadFrom(r io.Reader) (int64, error) {
+	buf := scratchPool.Get().(*[16 * 1024]byte)
+	defer scratchPool.Put(buf)
+
+	var n int64
+	for {
+		m, rerr := r.Read(buf[:])
+		if m > 0 {
+			m, err := w.encrypt(buf[:m])
+			n += int64(m)
+			if err != nil {
+				return n, err
 			}
-			c.nextByte = 0
 		}
-		dst[i] = src[i] ^ c.output[c.nextByte]
-		c.nextByte++
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
+
+// Encrypts chunk in place and writes it to the underlying writer. On a
+// short write the keystream is rewound to just past the bytes written.
+func (w *wrappedWriter) encrypt(chunk []byte) (int, error) {
+	saved := *w.c
+	w.c.XORKeyStream(chunk, chunk)
+	m, err := w.w.Write(chunk)
+	if m < len(chunk) {
+		*w.c = saved
+		w.c.Skip(uint64(m))
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+	}
+	return m, err
+}
+
+// Reports whether x and y share memory at any non-corresponding index,
+// mirroring the check in the standard library's crypto packages.
+func inexactOverlap(x, y []byte) bool {
+	if len(x) == 0 || len(y) == 0 || &x[0] == &y[0] {
+		return false
 	}
+	return uintptr(unsafe.Pointer(&x[0])) <= uintptr(unsafe.Pointer(&y[len(y)-1])) &&
+		uintptr(unsafe.Pointer(&y[0])) <= uintptr(unsafe.Pointer(&x[len(x)-1]))
 }
@@ -0,0 +1,35 @@
+// This is free and unencumbered software released into the public domain.
+
+//go:build amd64 && !purego
+
+package chacha
+
+import (
+	"golang.org/x/sys/cpu"
+)
+
+var (
+	useAVX2  = cpu.X86.HasAVX2
+	useSSSE3 = cpu.X86.HasSSSE3
+)
+
+//go:noescape
+func blocksAVX2(dst *[256]byte, state *[16]uint32, rounds int)
+
+//go:noescape
+func blocksSSSE3(dst *[256]byte, state *[16]uint32, rounds int)
+
+// Writes four consecutive blocks, starting at the state's counter, using
+// a vectorized core. The counter is incremented in 32 bits per block, and
+// is not updated in state. Reports false if no vector core is available.
+func blocks4(dst *[256]byte, state *[16]uint32, rounds int) bool {
+	switch {
+	case useAVX2:
+		blocksAVX2(dst, state, rounds)
+	case useSSSE3:
+		blocksSSSE3(dst, state, rounds)
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,227 @@
+// This is free and unencumbered software released into the public domain.
+
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// Each block is held as four rows of four words, with the diagonal
+// rounds done by rotating rows into columns. Rotations by 16 and 8 are
+// byte shuffles, while 12 and 7 are shift pairs.
+
+DATA rol16<>+0x00(SB)/8, $0x0504070601000302
+DATA rol16<>+0x08(SB)/8, $0x0D0C0F0E09080B0A
+DATA rol16<>+0x10(SB)/8, $0x0504070601000302
+DATA rol16<>+0x18(SB)/8, $0x0D0C0F0E09080B0A
+GLOBL rol16<>(SB), RODATA|NOPTR, $32
+
+DATA rol8<>+0x00(SB)/8, $0x0605040702010003
+DATA rol8<>+0x08(SB)/8, $0x0E0D0C0F0A09080B
+DATA rol8<>+0x10(SB)/8, $0x0605040702010003
+DATA rol8<>+0x18(SB)/8, $0x0E0D0C0F0A09080B
+GLOBL rol8<>(SB), RODATA|NOPTR, $32
+
+// Counter offsets for the low and high lanes of each register set
+DATA ctr01<>+0x00(SB)/8, $0
+DATA ctr01<>+0x08(SB)/8, $0
+DATA ctr01<>+0x10(SB)/8, $1
+DATA ctr01<>+0x18(SB)/8, $0
+GLOBL ctr01<>(SB), RODATA|NOPTR, $32
+
+DATA ctr23<>+0x00(SB)/8, $2
+DATA ctr23<>+0x08(SB)/8, $0
+DATA ctr23<>+0x10(SB)/8, $3
+DATA ctr23<>+0x18(SB)/8, $0
+GLOBL ctr23<>(SB), RODATA|NOPTR, $32
+
+#define QR_AVX2(a, b, c, d, t) \
+	VPADDD  b, a, a;      \
+	VPXOR   a, d, d;      \
+	VPSHUFB Y14, d, d;    \
+	VPADDD  d, c, c;      \
+	VPXOR   c, b, b;      \
+	VPSLLD  $12, b, t;    \
+	VPSRLD  $20, b, b;    \
+	VPXOR   t, b, b;      \
+	VPADDD  b, a, a;      \
+	VPXOR   a, d, d;      \
+	VPSHUFB Y15, d, d;    \
+	VPADDD  d, c, c;      \
+	VPXOR   c, b, b;      \
+	VPSLLD  $7, b, t;     \
+	VPSRLD  $25, b, b;    \
+	VPXOR   t, b, b
+
+#define DIAG_AVX2(b, c, d) \
+	VPSHUFD $0x39, b, b; \
+	VPSHUFD $0x4e, c, c; \
+	VPSHUFD $0x93, d, d
+
+#define UNDIAG_AVX2(b, c, d) \
+	VPSHUFD $0x93, b, b; \
+	VPSHUFD $0x4e, c, c; \
+	VPSHUFD $0x39, d, d
+
+// func blocksAVX2(dst *[256]byte, state *[16]uint32, rounds int)
+//
+// Two register sets, Y0-Y3 and Y4-Y7, each hold two blocks, one per
+// 128-bit lane.
+TEXT ·blocksAVX2(SB), NOSPLIT, $0-24
+	MOVQ dst+0(FP), DI
+	MOVQ state+8(FP), SI
+	MOVQ rounds+16(FP), CX
+
+	VMOVDQU rol16<>(SB), Y14
+	VMOVDQU rol8<>(SB), Y15
+	VBROADCASTI128 0(SI), Y8
+	VBROADCASTI128 16(SI), Y9
+	VBROADCASTI128 32(SI), Y10
+	VBROADCASTI128 48(SI), Y11
+	VPADDD ctr23<>(SB), Y11, Y7
+	VPADDD ctr01<>(SB), Y11, Y11
+
+	VMOVDQA Y8, Y0
+	VMOVDQA Y9, Y1
+	VMOVDQA Y10, Y2
+	VMOVDQA Y11, Y3
+	VMOVDQA Y8, Y4
+	VMOVDQA Y9, Y5
+	VMOVDQA Y10, Y6
+
+loop:
+	QR_AVX2(Y0, Y1, Y2, Y3, Y12)
+	QR_AVX2(Y4, Y5, Y6, Y7, Y13)
+	DIAG_AVX2(Y1, Y2, Y3)
+	DIAG_AVX2(Y5, Y6, Y7)
+	QR_AVX2(Y0, Y1, Y2, Y3, Y12)
+	QR_AVX2(Y4, Y5, Y6, Y7, Y13)
+	UNDIAG_AVX2(Y1, Y2, Y3)
+	UNDIAG_AVX2(Y5, Y6, Y7)
+	SUBQ $2, CX
+	JA   loop
+
+	VPADDD Y8, Y0, Y0
+	VPADDD Y9, Y1, Y1
+	VPADDD Y10, Y2, Y2
+	VPADDD Y11, Y3, Y3
+	VPADDD Y8, Y4, Y4
+	VPADDD Y9, Y5, Y5
+	VPADDD Y10, Y6, Y6
+	VBROADCASTI128 48(SI), Y11
+	VPADDD ctr23<>(SB), Y11, Y11
+	VPADDD Y11, Y7, Y7
+
+	VMOVDQU X0, 0(DI)
+	VMOVDQU X1, 16(DI)
+	VMOVDQU X2, 32(DI)
+	VMOVDQU X3, 48(DI)
+	VEXTRACTI128 $1, Y0, 64(DI)
+	VEXTRACTI128 $1, Y1, 80(DI)
+	VEXTRACTI128 $1, Y2, 96(DI)
+	VEXTRACTI128 $1, Y3, 112(DI)
+	VMOVDQU X4, 128(DI)
+	VMOVDQU X5, 144(DI)
+	VMOVDQU X6, 160(DI)
+	VMOVDQU X7, 176(DI)
+	VEXTRACTI128 $1, Y4, 192(DI)
+	VEXTRACTI128 $1, Y5, 208(DI)
+	VEXTRACTI128 $1, Y6, 224(DI)
+	VEXTRACTI128 $1, Y7, 240(DI)
+
+	VZEROUPPER
+	RET
+
+#define QR_SSSE3(a, b, c, d, t) \
+	PADDL  b, a;   \
+	PXOR   a, d;   \
+	PSHUFB X14, d; \
+	PADDL  d, c;   \
+	PXOR   c, b;   \
+	MOVO   b, t;   \
+	PSLLL  $12, t; \
+	PSRLL  $20, b; \
+	PXOR   t, b;   \
+	PADDL  b, a;   \
+	PXOR   a, d;   \
+	PSHUFB X15, d; \
+	PADDL  d, c;   \
+	PXOR   c, b;   \
+	MOVO   b, t;   \
+	PSLLL  $7, t;  \
+	PSRLL  $25, b; \
+	PXOR   t, b
+
+#define DIAG_SSSE3(b, c, d) \
+	PSHUFD $0x39, b, b; \
+	PSHUFD $0x4e, c, c; \
+	PSHUFD $0x93, d, d
+
+#define UNDIAG_SSSE3(b, c, d) \
+	PSHUFD $0x93, b, b; \
+	PSHUFD $0x4e, c, c; \
+	PSHUFD $0x39, d, d
+
+// func blocksSSSE3(dst *[256]byte, state *[16]uint32, rounds int)
+//
+// Two passes, each computing two blocks in register sets X0-X3 and
+// X4-X7. X11 tracks the counter row for the first block of each pass.
+TEXT ·blocksSSSE3(SB), NOSPLIT, $0-24
+	MOVQ dst+0(FP), DI
+	MOVQ state+8(FP), SI
+	MOVQ rounds+16(FP), DX
+
+	MOVOU rol16<>(SB), X14
+	MOVOU rol8<>(SB), X15
+	MOVOU ctr01<>+0x10(SB), X13
+	MOVOU 0(SI), X8
+	MOVOU 16(SI), X9
+	MOVOU 32(SI), X10
+	MOVOU 48(SI), X11
+	MOVQ  $2, BX
+
+pass:
+	MOVO  X8, X0
+	MOVO  X9, X1
+	MOVO  X10, X2
+	MOVO  X11, X3
+	MOVO  X8, X4
+	MOVO  X9, X5
+	MOVO  X10, X6
+	MOVO  X11, X7
+	PADDL X13, X7
+	MOVQ  DX, CX
+
+loop:
+	QR_SSSE3(X0, X1, X2, X3, X12)
+	QR_SSSE3(X4, X5, X6, X7, X12)
+	DIAG_SSSE3(X1, X2, X3)
+	DIAG_SSSE3(X5, X6, X7)
+	QR_SSSE3(X0, X1, X2, X3, X12)
+	QR_SSSE3(X4, X5, X6, X7, X12)
+	UNDIAG_SSSE3(X1, X2, X3)
+	UNDIAG_SSSE3(X5, X6, X7)
+	SUBQ $2, CX
+	JA   loop
+
+	PADDL X8, X0
+	PADDL X9, X1
+	PADDL X10, X2
+	PADDL X11, X3
+	MOVOU X0, 0(DI)
+	MOVOU X1, 16(DI)
+	MOVOU X2, 32(DI)
+	MOVOU X3, 48(DI)
+	PADDL X13, X11
+	PADDL X8, X4
+	PADDL X9, X5
+	PADDL X10, X6
+	PADDL X11, X7
+	MOVOU X4, 64(DI)
+	MOVOU X5, 80(DI)
+	MOVOU X6, 96(DI)
+	MOVOU X7, 112(DI)
+	PADDL X13, X11
+
+	ADDQ $128, DI
+	DECQ BX
+	JNZ  pass
+	RET
@@ -0,0 +1,66 @@
+// This is free and unencumbered software released into the public domain.
+
+//go:build amd64 && !purego
+
+package chacha
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVectorCores(t *testing.T) {
+	defer func(avx2, ssse3 bool) {
+		useAVX2, useSSSE3 = avx2, ssse3
+	}(useAVX2, useSSSE3)
+
+	cores := []struct {
+		name        string
+		avx2, ssse3 bool
+		supported   bool
+	}{
+		{"AVX2", true, false, useAVX2},
+		{"SSSE3", false, true, useSSSE3},
+	}
+
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i * 7)
+	}
+	iv := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	starts := []uint64{0, 1, 0xfffffffa, 0xfffffffc, 0x1fffffffb, 0xfffffffffffffff8}
+
+	for _, core := range cores {
+		if !core.supported {
+			t.Logf("%s not supported, skipping", core.name)
+			continue
+		}
+		for _, rounds := range []int{8, 12, 20} {
+			for _, ietf := range []bool{false, true} {
+				for _, start := range starts {
+					var c *Cipher
+					if ietf {
+						c, _ = NewIETF(key, iv, rounds)
+					} else {
+						c, _ = NewCipher(key, iv[:8], rounds)
+					}
+					c.Seek(start)
+					c.Read(make([]byte, 64))
+
+					useAVX2, useSSSE3 = false, false
+					want := make([]byte, 13*64)
+					wn, _ := c.Clone().Read(want)
+
+					useAVX2, useSSSE3 = core.avx2, core.ssse3
+					got := make([]byte, 13*64)
+					gn, _ := c.Read(got)
+
+					if gn != wn || !bytes.Equal(got, want) {
+						t.Errorf("%s, rounds %d, ietf %v, start %#x: mismatch",
+							core.name, rounds, ietf, start)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,10 @@
+// This is free and unencumbered software released into the public domain.
+
+//go:build !amd64 || purego
+
+package chacha
+
+// No vector core on this platform.
+func blocks4(dst *[256]byte, state *[16]uint32, rounds int) bool {
+	return false
+}
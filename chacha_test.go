@@ -2,8 +2,18 @@ package chacha
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"io"
+	"math"
+	"strconv"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestChaCha(t *testing.T) {
@@ -58,12 +68,28 @@ func TestChaCha(t *testing.T) {
 	// Test for panic at end of keystream
 	func() {
 		defer func() {
-			if r := recover(); r == nil {
-				t.Errorf("XORKeyStream() did not panic")
+			if r := recover(); r != ErrAlreadyExhausted {
+				t.Errorf("XORKeyStream(), got panic %v, want %v", r, ErrAlreadyExhausted)
 			}
 		}()
 		c.XORKeyStream(got[:], got[:])
 	}()
+
+	// Running out partway panics before writing anything
+	c.Seek(0xffffffffffffffff)
+	c.Read(got[:10])
+	got = [128]byte{}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrExhausted {
+				t.Errorf("XORKeyStream(), got panic %v, want %v", r, ErrExhausted)
+			}
+		}()
+		c.XORKeyStream(got[:55], got[:55])
+	}()
+	if got != [128]byte{} || c.Remaining() != 54 {
+		t.Errorf("XORKeyStream() wrote or advanced before panicking")
+	}
 }
 
 func BenchmarkChaCha(b *testing.B) {
@@ -75,3 +101,2485 @@ func BenchmarkChaCha(b *testing.B) {
 		c.XORKeyStream(buf[:], buf[:])
 	}
 }
+
+func BenchmarkBlock(b *testing.B) {
+	var in [16]uint32
+	var out [BlockSize]byte
+	b.SetBytes(BlockSize)
+	for i := 0; i < b.N; i++ {
+		Block(&out, &in, 20)
+	}
+}
+
+var timing = flag.Bool("timing", false, "run wall-clock timing tests")
+
+// TestConstantTime depends on wall-clock measurements, which are
+// unreliable on loaded machines, so it only runs with -timing.
+func TestConstantTime(t *testing.T) {
+	if !*timing {
+		t.Skip("timing test, enable with -timing")
+	}
+
+	// Compare the fastest of many runs, which filters out most noise,
+	// between all-zero and patterned keys and messages
+	measure := func(key, msg []byte) time.Duration {
+		c := New(key, key[:8], 20)
+		buf := make([]byte, len(msg))
+		best := time.Duration(math.MaxInt64)
+		for i := 0; i < 200; i++ {
+			start := time.Now()
+			for j := 0; j < 16; j++ {
+				c.XORKeyStream(buf, msg)
+			}
+			if d := time.Since(start); d < best {
+				best = d
+			}
+		}
+		return best
+	}
+	zero := make([]byte, 1024+32)
+	ones := make([]byte, len(zero))
+	for i := range ones {
+		ones[i] = byte(i*0x9e + 0x37)
+	}
+	a := measure(zero[:32], zero)
+	b := measure(ones[:32], ones)
+	if a > b*3/2 || b > a*3/2 {
+		t.Errorf("XORKeyStream() timing varies with data, %v vs %v", a, b)
+	}
+}
+
+func TestRounds(t *testing.T) {
+	var key [32]byte
+	var nonce [24]byte
+	for _, rounds := range []int{8, 12, 20} {
+		ietf, _ := NewIETF(key[:], nonce[:12], rounds)
+		x, _ := NewX(key[:], nonce[:], rounds)
+		for _, c := range []*Cipher{New(key[:], nonce[:], rounds), ietf, x} {
+			if got := c.Rounds(); got != rounds {
+				t.Errorf("Rounds(), got %v, want %v", got, rounds)
+			}
+		}
+	}
+}
+
+func TestNewFromWords(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	iv := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	var keyWords [8]uint32
+	for i := range keyWords {
+		keyWords[i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	nonceWords := [2]uint32{
+		binary.LittleEndian.Uint32(iv[0:]),
+		binary.LittleEndian.Uint32(iv[4:]),
+	}
+
+	c := NewFromWords(keyWords, nonceWords, 12)
+	d := New(key, iv, 12)
+	if *c != *d {
+		t.Errorf("NewFromWords(), got %v, want %v", c.input, d.input)
+	}
+	if c.input[4] != 0x03020100 || c.input[15] != 0x08070605 {
+		t.Errorf("NewFromWords(), wrong word order: %v", c.input)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != ErrRounds {
+				t.Errorf("NewFromWords(7 rounds), got panic %v, want %v", r, ErrRounds)
+			}
+		}()
+		NewFromWords(keyWords, nonceWords, 7)
+	}()
+}
+
+func TestNewFromReader(t *testing.T) {
+	seed := make([]byte, 40)
+	for i := range seed {
+		seed[i] = byte(i + 1)
+	}
+	c, key, iv, err := NewFromReader(bytes.NewReader(seed), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key[:], seed[:32]) || !bytes.Equal(iv[:], seed[32:]) {
+		t.Errorf("NewFromReader(), got %v, %v, want %v, %v",
+			key, iv, seed[:32], seed[32:])
+	}
+	want := make([]byte, 100)
+	New(seed[:32], seed[32:], 12).Read(want)
+	got := make([]byte, 100)
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("NewFromReader(), got %v, want %v", got, want)
+	}
+
+	if _, _, _, err := NewFromReader(bytes.NewReader(seed[:39]), 20); err != io.ErrUnexpectedEOF {
+		t.Errorf("NewFromReader(short), got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	r := bytes.NewReader(seed)
+	if _, _, _, err := NewFromReader(r, 7); err != ErrRounds || r.Len() != len(seed) {
+		t.Errorf("NewFromReader(7 rounds), got %v, want %v", err, ErrRounds)
+	}
+}
+
+func TestNewCipher(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+
+	if _, err := NewCipher(key[:16], iv[:], 20); err != ErrKeySize {
+		t.Errorf("NewCipher(short key), got %v, want %v", err, ErrKeySize)
+	}
+	if _, err := NewCipher(key[:], iv[:4], 20); err != ErrNonceSize {
+		t.Errorf("NewCipher(short iv), got %v, want %v", err, ErrNonceSize)
+	}
+	long := make([]byte, 64)
+	if _, err := NewCipher(long, iv[:], 20); err != ErrKeySize {
+		t.Errorf("NewCipher(long key), got %v, want %v", err, ErrKeySize)
+	}
+	if _, err := NewCipher(key[:], long[:9], 20); err != ErrNonceSize {
+		t.Errorf("NewCipher(long iv), got %v, want %v", err, ErrNonceSize)
+	}
+
+	// Every constructor requires exact sizes rather than truncating
+	sizes := []struct {
+		name    string
+		new     func(key, iv []byte, rounds int) (*Cipher, error)
+		key, iv int
+		err     error
+	}{
+		{"NewKey128", NewKey128, 17, 8, ErrKeySize},
+		{"NewKey128", NewKey128, 16, 9, ErrNonceSize},
+		{"NewIETF", NewIETF, 33, 12, ErrKeySize},
+		{"NewIETF", NewIETF, 32, 13, ErrNonceSize},
+		{"NewCounter32", NewCounter32, 33, 8, ErrKeySize},
+		{"NewCounter32", NewCounter32, 32, 9, ErrNonceSize},
+		{"NewX", NewX, 33, 24, ErrKeySize},
+		{"NewX", NewX, 32, 25, ErrNonceSize},
+	}
+	for _, s := range sizes {
+		if _, err := s.new(long[:s.key], long[:s.iv], 20); err != s.err {
+			t.Errorf("%s(%d-byte key, %d-byte iv), got %v, want %v",
+				s.name, s.key, s.iv, err, s.err)
+		}
+	}
+
+	for _, rounds := range []int{-2, 0, 7, 10, 21} {
+		if _, err := NewCipher(key[:], iv[:], rounds); err != ErrRounds {
+			t.Errorf("NewCipher(%d rounds), got %v, want %v",
+				rounds, err, ErrRounds)
+		}
+	}
+	if _, err := NewCipher(key[:], iv[:], 20); err != nil {
+		t.Errorf("NewCipher(), got %v, want nil", err)
+	}
+	named := []struct {
+		f      func(key, iv []byte) (*Cipher, error)
+		rounds int
+	}{{NewChaCha8, 8}, {NewChaCha12, 12}, {NewChaCha20, 20}}
+	for _, n := range named {
+		c, err := n.f(key[:], iv[:])
+		if err != nil || c.rounds != n.rounds {
+			t.Errorf("NewChaCha%d(), got %v, %v", n.rounds, c, err)
+		}
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != ErrKeySize {
+				t.Errorf("New(short key), got panic %v, want %v", r, ErrKeySize)
+			}
+		}()
+		New(key[:16], iv[:], 20)
+	}()
+	func() {
+		defer func() {
+			if r := recover(); r != ErrRounds {
+				t.Errorf("New(7 rounds), got panic %v, want %v", r, ErrRounds)
+			}
+		}()
+		New(key[:], iv[:], 7)
+	}()
+}
+
+func TestNewPadded(t *testing.T) {
+	key := make([]byte, 32)
+	short := []byte{1, 2, 3, 4, 5, 6}
+	c, err := NewPadded(key, short, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 64)
+	New(key, []byte{1, 2, 3, 4, 5, 6, 0, 0}, 20).Read(want)
+	got := make([]byte, 64)
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("NewPadded(), got %x, want %x", got, want)
+	}
+
+	if _, err := NewPadded(key, nil, 20); err != nil {
+		t.Errorf("NewPadded(empty iv), got %v", err)
+	}
+	if _, err := NewPadded(key, make([]byte, 9), 20); err != ErrNonceSize {
+		t.Errorf("NewPadded(long iv), got %v, want %v", err, ErrNonceSize)
+	}
+	if _, err := NewCipher(key, short, 20); err != ErrNonceSize {
+		t.Errorf("NewCipher(short iv), got %v, want %v", err, ErrNonceSize)
+	}
+}
+
+func TestIETF(t *testing.T) {
+	// RFC 8439, section 2.4.2
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce := []byte{0, 0, 0, 0, 0, 0, 0, 0x4a, 0, 0, 0, 0}
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: " +
+		"If I could offer you only one tip for the future, " +
+		"sunscreen would be it.")
+	want := []byte{
+		0x6e, 0x2e, 0x35, 0x9a, 0x25, 0x68, 0xf9, 0x80,
+		0x41, 0xba, 0x07, 0x28, 0xdd, 0x0d, 0x69, 0x81,
+		0xe9, 0x7e, 0x7a, 0xec, 0x1d, 0x43, 0x60, 0xc2,
+		0x0a, 0x27, 0xaf, 0xcc, 0xfd, 0x9f, 0xae, 0x0b,
+		0xf9, 0x1b, 0x65, 0xc5, 0x52, 0x47, 0x33, 0xab,
+		0x8f, 0x59, 0x3d, 0xab, 0xcd, 0x62, 0xb3, 0x57,
+		0x16, 0x39, 0xd6, 0x24, 0xe6, 0x51, 0x52, 0xab,
+		0x8f, 0x53, 0x0c, 0x35, 0x9f, 0x08, 0x61, 0xd8,
+		0x07, 0xca, 0x0d, 0xbf, 0x50, 0x0d, 0x6a, 0x61,
+		0x56, 0xa3, 0x8e, 0x08, 0x8a, 0x22, 0xb6, 0x5e,
+		0x52, 0xbc, 0x51, 0x4d, 0x16, 0xcc, 0xf8, 0x06,
+		0x81, 0x8c, 0xe9, 0x1a, 0xb7, 0x79, 0x37, 0x36,
+		0x5a, 0xf9, 0x0b, 0xbf, 0x74, 0xa3, 0x5b, 0xe6,
+		0xb4, 0x0b, 0x8e, 0xed, 0xf2, 0x78, 0x5e, 0x42,
+		0x87, 0x4d,
+	}
+
+	c, err := NewIETF(key, nonce, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Seek(1)
+	got := make([]byte, len(plaintext))
+	c.XORKeyStream(got, plaintext)
+	if !bytes.Equal(got, want) {
+		t.Errorf("XORKeyStream(), got %v, want %v", got, want)
+	}
+
+	// The 32-bit counter is exhausted after 2^32 blocks
+	c.Seek(0xffffffff)
+	n, err := c.Read(make([]byte, 70))
+	if n != 64 {
+		t.Errorf("Read(), got %v, want %v", n, 64)
+	}
+	if err != io.EOF {
+		t.Errorf("Read(), got %v, want %v", err, io.EOF)
+	}
+
+	if _, err := NewIETF(key, nonce[:8], 20); err != ErrNonceSize {
+		t.Errorf("NewIETF(short nonce), got %v, want %v", err, ErrNonceSize)
+	}
+}
+
+func TestStreamsEqual(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	a := New(key[:], iv[:], 20)
+	b := New(key[:], iv[:], 20)
+	a.Read(make([]byte, 100))
+	b.Seek(1)
+	b.Read(make([]byte, 36))
+	if !StreamsEqual(a, b, 10000) {
+		t.Errorf("StreamsEqual(same position), got false")
+	}
+	if a.Tell() != 100 || b.Tell() != 100 {
+		t.Errorf("StreamsEqual() moved the ciphers to %v, %v", a.Tell(), b.Tell())
+	}
+	b.Read(make([]byte, 1))
+	if StreamsEqual(a, b, 10000) {
+		t.Errorf("StreamsEqual(offset by one), got true")
+	}
+	if !StreamsEqual(a, b, 0) {
+		t.Errorf("StreamsEqual(0 bytes), got false")
+	}
+	if StreamsEqual(a, New(key[:], iv[:], 12), 1) {
+		t.Errorf("StreamsEqual(different rounds), got true")
+	}
+
+	// Streams that end together are equal, those that do not are not
+	a.Seek(math.MaxUint64)
+	b.Seek(math.MaxUint64)
+	if !StreamsEqual(a, b, 100) {
+		t.Errorf("StreamsEqual(both ending), got false")
+	}
+	c := New(key[:], iv[:], 20)
+	c.SetWrapOnOverflow(true)
+	c.Seek(math.MaxUint64)
+	if StreamsEqual(a, c, 100) {
+		t.Errorf("StreamsEqual(one ending), got true")
+	}
+
+	// Unbuffered ciphers compare at any length
+	u1, _ := NewUnbuffered(key[:], iv[:], 20)
+	u2, _ := NewUnbuffered(key[:], iv[:], 20)
+	if !StreamsEqual(u1, u2, 100) || !StreamsEqual(u1, New(key[:], iv[:], 20), 100) {
+		t.Errorf("StreamsEqual(unbuffered, 100 bytes), got false")
+	}
+	if u1.Tell() != 0 || !u1.unbuf {
+		t.Errorf("StreamsEqual() changed an unbuffered cipher")
+	}
+}
+
+func TestChaCha20(t *testing.T) {
+	// RFC 8439, section 2.4.2
+	var key [32]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce := []byte{0, 0, 0, 0, 0, 0, 0, 0x4a, 0, 0, 0, 0}
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: " +
+		"If I could offer you only one tip for the future, " +
+		"sunscreen would be it.")
+	want, _ := hex.DecodeString("" +
+		"6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b" +
+		"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8" +
+		"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736" +
+		"5af90bbf74a35be6b40b8eedf2785e42874d")
+	got := make([]byte, len(plaintext))
+	ChaCha20(key, nonce, 1, got, plaintext)
+	if !bytes.Equal(got, want) {
+		t.Errorf("ChaCha20(), got %x, want %x", got, want)
+	}
+
+	// The final block is usable, but not past it
+	c, _ := NewIETF(key[:], nonce, 20)
+	c.Seek(0xffffffff)
+	ref := make([]byte, 64)
+	c.Read(ref)
+	ChaCha20(key, nonce, 0xffffffff, got[:64], make([]byte, 64))
+	if !bytes.Equal(got[:64], ref) {
+		t.Errorf("ChaCha20(last block), got %x, want %x", got[:64], ref)
+	}
+	panics := []struct {
+		nonce []byte
+		n     int
+		want  error
+	}{{nonce[:8], 1, ErrNonceSize}, {nonce, 65, ErrExhausted}}
+	for _, p := range panics {
+		func() {
+			defer func() {
+				if r := recover(); r != p.want {
+					t.Errorf("ChaCha20(), got panic %v, want %v", r, p.want)
+				}
+			}()
+			ChaCha20(key, p.nonce, 0xffffffff, got[:p.n], got[:p.n])
+		}()
+	}
+}
+
+func TestNewWithCounter(t *testing.T) {
+	key := make([]byte, 32)
+	iv := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	for _, ctr := range []uint64{0, 1, 0xffffffff, math.MaxUint64} {
+		c, err := NewWithCounter(key, iv, ctr, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := New(key, iv, 20)
+		d.Seek(ctr)
+		if c.Tell() != d.Tell() || !StreamsEqual(c, d, 256) {
+			t.Errorf("NewWithCounter(%#x) differs from Seek", ctr)
+		}
+	}
+	if _, err := NewWithCounter(key, iv[:7], 1, 20); err != ErrNonceSize {
+		t.Errorf("NewWithCounter(short iv), got %v, want %v", err, ErrNonceSize)
+	}
+	if _, err := NewWithCounter(key, iv, 1, 10); err != ErrRounds {
+		t.Errorf("NewWithCounter(10 rounds), got %v, want %v", err, ErrRounds)
+	}
+}
+
+func TestCounter32(t *testing.T) {
+	key := make([]byte, 32)
+	iv := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	c, err := NewCounter32(key, iv, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Identical to the 64-bit layout until the low word overflows
+	want := make([]byte, 256)
+	d := New(key, iv, 12)
+	d.Seek(0xfffffffc)
+	d.Read(want)
+	c.Seek(0xfffffffc)
+	got := make([]byte, 300)
+	n, err := c.Read(got)
+	if n != 256 || err != io.EOF {
+		t.Errorf("Read(), got %v, %v, want 256, %v", n, err, io.EOF)
+	}
+	if !bytes.Equal(got[:n], want) {
+		t.Errorf("Read(), got %x, want %x", got[:n], want)
+	}
+
+	c.Reset()
+	if r := c.Remaining(); r != 1<<38 {
+		t.Errorf("Remaining(), got %v, want %v", r, uint64(1)<<38)
+	}
+
+	if _, err := NewCounter32(key, iv[:7], 20); err != ErrNonceSize {
+		t.Errorf("NewCounter32(short iv), got %v, want %v", err, ErrNonceSize)
+	}
+	if _, err := NewCounter32(key[:16], iv, 20); err != ErrKeySize {
+		t.Errorf("NewCounter32(short key), got %v, want %v", err, ErrKeySize)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	// RFC 8439, section 2.3.2
+	in := [16]uint32{
+		0x61707865, 0x3320646e, 0x79622d32, 0x6b206574,
+		0x03020100, 0x07060504, 0x0b0a0908, 0x0f0e0d0c,
+		0x13121110, 0x17161514, 0x1b1a1918, 0x1f1e1d1c,
+		0x00000001, 0x09000000, 0x4a000000, 0x00000000,
+	}
+	want := [64]byte{
+		0x10, 0xf1, 0xe7, 0xe4, 0xd1, 0x3b, 0x59, 0x15,
+		0x50, 0x0f, 0xdd, 0x1f, 0xa3, 0x20, 0x71, 0xc4,
+		0xc7, 0xd1, 0xf4, 0xc7, 0x33, 0xc0, 0x68, 0x03,
+		0x04, 0x22, 0xaa, 0x9a, 0xc3, 0xd4, 0x6c, 0x4e,
+		0xd2, 0x82, 0x64, 0x46, 0x07, 0x9f, 0xaa, 0x09,
+		0x14, 0xc2, 0xd7, 0x05, 0xd9, 0x8b, 0x02, 0xa2,
+		0xb5, 0x12, 0x9c, 0xd1, 0xde, 0x16, 0x4e, 0xb9,
+		0xcb, 0xd0, 0x83, 0xe8, 0xa2, 0x50, 0x3c, 0x4e,
+	}
+	saved := in
+	var got [64]byte
+	Block(&got, &in, 20)
+	if got != want {
+		t.Errorf("Block(), got %x, want %x", got, want)
+	}
+	if in != saved {
+		t.Errorf("Block() modified its input")
+	}
+}
+
+func TestXChaCha(t *testing.T) {
+	// draft-irtf-cfrg-xchacha-03, section A.3.2 (first block)
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(0x80 + i)
+	}
+	nonce := make([]byte, 24)
+	for i := range nonce {
+		nonce[i] = byte(0x40 + i)
+	}
+	nonce[23] = 0x58
+	plaintext := []byte("The dhole (pronounced \"dole\") is also known as " +
+		"the Asiatic wild ")
+	want := []byte{
+		0x7d, 0x0a, 0x2e, 0x6b, 0x7f, 0x7c, 0x65, 0xa2,
+		0x36, 0x54, 0x26, 0x30, 0x29, 0x4e, 0x06, 0x3b,
+		0x7a, 0xb9, 0xb5, 0x55, 0xa5, 0xd5, 0x14, 0x9a,
+		0xa2, 0x1e, 0x4a, 0xe1, 0xe4, 0xfb, 0xce, 0x87,
+		0xec, 0xc8, 0xe0, 0x8a, 0x8b, 0x5e, 0x35, 0x0a,
+		0xbe, 0x62, 0x2b, 0x2f, 0xfa, 0x61, 0x7b, 0x20,
+		0x2c, 0xfa, 0xd7, 0x20, 0x32, 0xa3, 0x03, 0x7e,
+		0x76, 0xff, 0xdc, 0xdc, 0x43, 0x76, 0xee, 0x05,
+	}
+
+	c, err := NewX(key, nonce, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Seek(1)
+	got := make([]byte, len(plaintext))
+	c.XORKeyStream(got, plaintext)
+	if !bytes.Equal(got, want) {
+		t.Errorf("XORKeyStream(), got %v, want %v", got, want)
+	}
+
+	if _, err := NewX(key, nonce[:12], 20); err != ErrNonceSize {
+		t.Errorf("NewX(short nonce), got %v, want %v", err, ErrNonceSize)
+	}
+}
+
+func TestHChaCha20(t *testing.T) {
+	// draft-irtf-cfrg-xchacha-03, section 2.2.1
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce := []byte{
+		0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x4a,
+		0x00, 0x00, 0x00, 0x00, 0x31, 0x41, 0x59, 0x27,
+	}
+	want := [32]byte{
+		0x82, 0x41, 0x3b, 0x42, 0x27, 0xb2, 0x7b, 0xfe,
+		0xd3, 0x0e, 0x42, 0x50, 0x8a, 0x87, 0x7d, 0x73,
+		0xa0, 0xf9, 0xe4, 0xd5, 0x8a, 0x74, 0xa8, 0x53,
+		0xc1, 0x2e, 0xc4, 0x13, 0x26, 0xd3, 0xec, 0xdc,
+	}
+	got, err := HChaCha20(key, nonce, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("HChaCha20(), got %v, want %v", got, want)
+	}
+
+	if _, err := HChaCha20(key, nonce[:12], 20); err != ErrNonceSize {
+		t.Errorf("HChaCha20(short nonce), got %v, want %v", err, ErrNonceSize)
+	}
+}
+
+func BenchmarkXORKeyStream(b *testing.B) {
+	var key [32]byte
+	var iv [8]byte
+	buf := make([]byte, 64<<10)
+	c := New(key[:], iv[:], 20)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		c.XORKeyStream(buf, buf)
+	}
+}
+
+func BenchmarkXORKeyStreamSizes(b *testing.B) {
+	for _, size := range []int{16, 64, 576, 1500, 16384} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			var key [32]byte
+			var iv [8]byte
+			c := New(key[:], iv[:], 20)
+			buf := make([]byte, size)
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				c.XORKeyStream(buf, buf)
+			}
+		})
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	var key [32]byte
+	var iv [8]byte
+	buf := make([]byte, 64<<10)
+	c := New(key[:], iv[:], 20)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		c.Read(buf)
+	}
+}
+
+// BenchmarkReadLarge reads 1 MiB per call, block aligned and with an
+// unaligned head and tail, to show that the middle goes straight into p.
+func BenchmarkReadLarge(b *testing.B) {
+	for _, offset := range []int{0, 1} {
+		b.Run(strconv.Itoa(offset), func(b *testing.B) {
+			var key [32]byte
+			var iv [8]byte
+			c := New(key[:], iv[:], 20)
+			c.SeekByte(uint64(offset))
+			buf := make([]byte, 1<<20)
+			b.SetBytes(int64(len(buf)))
+			for i := 0; i < b.N; i++ {
+				c.Read(buf)
+			}
+		})
+	}
+}
+
+func TestReadLargeAllocs(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	c.SeekByte(3)
+	buf := make([]byte, 1<<20+5)
+	if n := testing.AllocsPerRun(10, func() { c.Read(buf) }); n != 0 {
+		t.Errorf("Read(1 MiB) allocated %v times, want 0", n)
+	}
+}
+
+func TestRead(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 1000)
+	c.XORKeyStream(want, want)
+
+	// Reads of assorted sizes, straddling block boundaries
+	c.Seek(0)
+	got := make([]byte, len(want))
+	for i, n := 0, 1; i < len(got); n = n*3 + 1 {
+		if i+n > len(got) {
+			n = len(got) - i
+		}
+		c.Read(got[i : i+n])
+		i += n
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Read(), got %v, want %v", got, want)
+	}
+
+	// Exhaustion in the middle of a multi-block read
+	c.Seek(0xfffffffffffffffe)
+	c.Read(got[:10])
+	n, err := c.Read(got[:200])
+	if n != 118 {
+		t.Errorf("Read(), got %v, want %v", n, 118)
+	}
+	if err != io.EOF {
+		t.Errorf("Read(), got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReadEOF(t *testing.T) {
+	var key [32]byte
+	var nonce [12]byte
+	ietf, _ := NewIETF(key[:], nonce[:], 20)
+	tests := []struct {
+		c    *Cipher
+		last uint64
+	}{
+		{New(key[:], nonce[:8], 20), 0xffffffffffffffff},
+		{ietf, 0xffffffff},
+	}
+	for _, test := range tests {
+		c := test.c
+		// Ending exactly on the last of two blocks, in various ways
+		reads := [][]int{{128}, {1, 127}, {127, 1}, {100, 28}, {64, 64}, {60, 68}}
+		for _, sizes := range reads {
+			c.Seek(test.last - 1)
+			for _, size := range sizes {
+				if n, err := c.Read(make([]byte, size)); n != size || err != nil {
+					t.Errorf("Read(%v), got %v, %v, want %v, nil",
+						sizes, n, err, size)
+				}
+			}
+			if n, err := c.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+				t.Errorf("Read(%v) then Read(1), got %v, %v, want 0, EOF",
+					sizes, n, err)
+			}
+		}
+
+		// Overshooting returns the remainder with io.EOF
+		c.Seek(test.last - 1)
+		c.Read(make([]byte, 10))
+		if n, err := c.Read(make([]byte, 200)); n != 118 || err != io.EOF {
+			t.Errorf("Read(200), got %v, %v, want 118, EOF", n, err)
+		}
+		if n, err := c.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("Read(1), got %v, %v, want 0, EOF", n, err)
+		}
+	}
+}
+
+func TestReadEmpty(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	c.SetCounter(5)
+	want := *c
+	if n, err := c.Read(nil); n != 0 || err != nil {
+		t.Errorf("Read(nil), got %v, %v, want 0, nil", n, err)
+	}
+	if *c != want {
+		t.Errorf("Read(nil) changed the cipher")
+	}
+
+	// Even an exhausted cipher reports no error
+	c.Seek(0xffffffffffffffff)
+	c.Read(make([]byte, 64))
+	want = *c
+	if n, err := c.Read([]byte{}); n != 0 || err != nil {
+		t.Errorf("Read(empty) at EOF, got %v, %v, want 0, nil", n, err)
+	}
+	if *c != want {
+		t.Errorf("Read(empty) at EOF changed the cipher")
+	}
+}
+
+func TestReadByte(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 200)
+	c.Read(want)
+
+	c.Reset()
+	c.Read(make([]byte, 3))
+	for i, w := range want[3:] {
+		if b, err := c.ReadByte(); b != w || err != nil {
+			t.Fatalf("ReadByte() at %d, got %v, %v, want %v, nil", i+3, b, err, w)
+		}
+	}
+
+	c.Seek(0xffffffffffffffff)
+	c.Read(make([]byte, 63))
+	if _, err := c.ReadByte(); err != nil {
+		t.Errorf("ReadByte() of last byte, got %v", err)
+	}
+	if _, err := c.ReadByte(); err != io.EOF {
+		t.Errorf("ReadByte() at end, got %v, want %v", err, io.EOF)
+	}
+
+	c.Reset()
+	if allocs := testing.AllocsPerRun(100, func() { c.ReadByte() }); allocs != 0 {
+		t.Errorf("ReadByte(), got %v allocations, want 0", allocs)
+	}
+}
+
+func TestReadProgress(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 40000)
+	c.Read(want)
+
+	c.Reset()
+	c.Read(make([]byte, 5))
+	got := make([]byte, len(want)-5)
+	var calls []int
+	n, err := c.ReadProgress(got, func(done int) { calls = append(calls, done) })
+	if n != len(got) || err != nil || !bytes.Equal(got, want[5:]) {
+		t.Errorf("ReadProgress(), got %d, %v, want %d, nil", n, err, len(got))
+	}
+	wantCalls := []int{16 << 10, 32 << 10, len(got)}
+	if len(calls) != len(wantCalls) {
+		t.Fatalf("ReadProgress() callbacks, got %v, want %v", calls, wantCalls)
+	}
+	for i := range calls {
+		if calls[i] != wantCalls[i] {
+			t.Errorf("ReadProgress() callbacks, got %v, want %v", calls, wantCalls)
+		}
+	}
+
+	// EOF behaves as in Read
+	c.Seek(math.MaxUint64 - 1)
+	calls = nil
+	n, err = c.ReadProgress(make([]byte, 200), func(done int) { calls = append(calls, done) })
+	if n != 128 || err != io.EOF {
+		t.Errorf("ReadProgress() at end, got %d, %v, want 128, %v", n, err, io.EOF)
+	}
+	if len(calls) != 1 || calls[0] != 128 {
+		t.Errorf("ReadProgress() callbacks at end, got %v, want [128]", calls)
+	}
+	n, err = c.ReadProgress(nil, nil)
+	if n != 0 || err != nil {
+		t.Errorf("ReadProgress(empty), got %d, %v, want 0, nil", n, err)
+	}
+}
+
+func TestSeekMax(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	c.Seek(math.MaxUint64)
+
+	// The final block starts 64 bytes before the 2^70-byte end, which
+	// Tell reports modulo 2^64
+	if got, want := c.Tell(), uint64(math.MaxUint64-63); got != want {
+		t.Errorf("Tell(), got %#x, want %#x", got, want)
+	}
+	if got := c.Remaining(); got != 64 {
+		t.Errorf("Remaining(), got %d, want 64", got)
+	}
+
+	in := c.State()
+	var want [BlockSize]byte
+	in[12], in[13] = 0xffffffff, 0xffffffff
+	Block(&want, &in, 20)
+
+	buf := make([]byte, 100)
+	n, _ := c.Read(buf)
+	if n != 64 || !bytes.Equal(buf[:n], want[:]) {
+		t.Errorf("Read(), got %d bytes %x, want %x", n, buf[:n], want)
+	}
+	if n, err := c.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read() at end, got %d, %v, want 0, %v", n, err, io.EOF)
+	}
+	if !c.Exhausted() {
+		t.Errorf("Exhausted(), got false")
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	want := make([]byte, 96<<10)
+	New(key[:], iv[:], 20).Read(want)
+
+	// 96 KiB at 512 KiB/s should take about 190ms
+	c := New(key[:], iv[:], 20)
+	r := c.ThrottledReader(512 << 10)
+	got := make([]byte, len(want))
+	start := time.Now()
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if !bytes.Equal(got, want) {
+		t.Errorf("ThrottledReader() keystream differs from Read()")
+	}
+	if elapsed < 150*time.Millisecond {
+		t.Errorf("ThrottledReader() took %v, want at least 150ms", elapsed)
+	}
+
+	// Reads are trimmed to whole blocks unless p is smaller than one
+	r = New(key[:], iv[:], 20).ThrottledReader(1 << 30)
+	for _, size := range []int{10, 64, 100, 1000} {
+		want := size &^ 63
+		if size < 64 {
+			want = size
+		}
+		if n, err := r.Read(got[:size]); n != want || err != nil {
+			t.Errorf("Read(%d bytes), got %d, %v, want %d, nil", size, n, err, want)
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("ThrottledReader(0) did not panic")
+			}
+		}()
+		c.ThrottledReader(0)
+	}()
+}
+
+func TestExhaustionBoundary(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	want := make([]byte, 64)
+	c := New(key[:], iv[:], 20)
+	c.Seek(0xffffffffffffffff)
+	c.Read(want)
+
+	// Each way of consuming keystream, reading n bytes per call
+	consumers := []struct {
+		name string
+		read func(c *Cipher, p []byte) error
+	}{
+		{"Read", func(c *Cipher, p []byte) error {
+			n, err := c.Read(p)
+			if n != len(p) {
+				return io.EOF
+			}
+			return err
+		}},
+		{"XORKeyStream", func(c *Cipher, p []byte) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = r.(error)
+				}
+			}()
+			c.XORKeyStream(p, p)
+			return nil
+		}},
+		{"ReadByte", func(c *Cipher, p []byte) error {
+			for i := range p {
+				b, err := c.ReadByte()
+				if err != nil {
+					return err
+				}
+				p[i] = b
+			}
+			return nil
+		}},
+	}
+
+	for _, consumer := range consumers {
+		for _, first := range []int{1, 63, 64} {
+			forceState(c, 0xffffffffffffffff, len(c.output), false)
+
+			// Generating the final block sets eof, with its bytes intact
+			got := make([]byte, 64)
+			if err := consumer.read(c, got[:first]); err != nil {
+				t.Errorf("%s(%d), got %v", consumer.name, first, err)
+			}
+			if !eofFlag(c) {
+				t.Errorf("%s(%d), eof not set by the final block",
+					consumer.name, first)
+			}
+			if first < 64 && c.Exhausted() {
+				t.Errorf("%s(%d), exhausted early", consumer.name, first)
+			}
+			if err := consumer.read(c, got[first:]); err != nil {
+				t.Errorf("%s(%d) of the last bytes, got %v",
+					consumer.name, 64-first, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s(), got %v, want %v", consumer.name, got, want)
+			}
+
+			// The next call fails
+			if !c.Exhausted() {
+				t.Errorf("%s(), not exhausted at the end", consumer.name)
+			}
+			err := consumer.read(c, make([]byte, 1))
+			if err != io.EOF && err != ErrExhausted && err != ErrAlreadyExhausted {
+				t.Errorf("%s() when exhausted, got %v", consumer.name, err)
+			}
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 8)
+	want := make([]byte, 10*64)
+	c.Read(want)
+
+	c.Reset()
+	c.Read(make([]byte, 3))
+	got := c.Blocks(9)
+	if !bytes.Equal(got, want[64:]) {
+		t.Errorf("Blocks(9), got %x, want %x", got, want[64:])
+	}
+	if c.Tell() != 10*64 {
+		t.Errorf("Tell() after Blocks(), got %v, want %v", c.Tell(), 10*64)
+	}
+	if got := c.Blocks(0); len(got) != 0 {
+		t.Errorf("Blocks(0), got %d bytes", len(got))
+	}
+
+	c.Seek(math.MaxUint64 - 2)
+	if got := c.Blocks(5); len(got) != 3*64 || !c.Exhausted() {
+		t.Errorf("Blocks(5) at end, got %d bytes, exhausted %v",
+			len(got), c.Exhausted())
+	}
+	if got := c.Blocks(1); len(got) != 0 {
+		t.Errorf("Blocks(1) when exhausted, got %d bytes", len(got))
+	}
+}
+
+func TestFillRing(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	want := make([]byte, 8*64)
+	New(key[:], iv[:], 12).Read(want)
+
+	for _, size := range []int{64, 100, 256, 300} {
+		for _, start := range []int{0, 1, 36, size - 1} {
+			c := New(key[:], iv[:], 12)
+			ring := make([]byte, size)
+			n := c.FillRing(ring, start)
+			if n != size/64*64 {
+				t.Errorf("FillRing(%d, %d), got %v, want %v", size, start, n, size/64*64)
+			}
+			for i := 0; i < n; i++ {
+				if ring[(start+i)%size] != want[i] {
+					t.Errorf("FillRing(%d, %d), wrong byte at %d", size, start, i)
+					break
+				}
+			}
+			if c.Tell() != uint64(n) {
+				t.Errorf("Tell() after FillRing(), got %v, want %v", c.Tell(), n)
+			}
+			if n := c.FillRing(ring, start); n != size/64*64 ||
+				ring[start] != want[size/64*64] {
+				t.Errorf("FillRing(%d, %d) again, got %v", size, start, n)
+			}
+		}
+	}
+
+	// Starts with a block left whole by Seek, and stops when exhausted
+	c := New(key[:], iv[:], 12)
+	c.Seek(math.MaxUint64 - 1)
+	ring := make([]byte, 200)
+	if n := c.FillRing(ring, 150); n != 128 || !c.Exhausted() {
+		t.Errorf("FillRing() at end, got %v, exhausted %v", n, c.Exhausted())
+	}
+	if n := c.FillRing(nil, 0); n != 0 {
+		t.Errorf("FillRing(nil), got %v", n)
+	}
+}
+
+func TestKeyStream(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 300)
+	c.XORKeyStream(want[:7], want[:7])
+	c.XORKeyStream(want[7:], want[7:])
+
+	c.Seek(0)
+	got := make([]byte, len(want))
+	c.KeyStream(got[:7])
+	c.KeyStream(got[7:])
+	if !bytes.Equal(got, want) {
+		t.Errorf("KeyStream(), got %v, want %v", got, want)
+	}
+
+	c.Seek(0xffffffffffffffff)
+	func() {
+		defer func() {
+			if r := recover(); r != ErrExhausted {
+				t.Errorf("KeyStream(), got panic %v, want %v", r, ErrExhausted)
+			}
+		}()
+		c.KeyStream(got[:65])
+	}()
+}
+
+func TestReset(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 100)
+	c.Read(want)
+
+	c.Reset()
+	got := make([]byte, len(want))
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Reset(), got %v, want %v", got, want)
+	}
+
+	// Reset also recovers from exhaustion
+	c.Seek(0xffffffffffffffff)
+	c.Read(make([]byte, 100))
+	c.Reset()
+	if n, err := c.Read(got); n != len(got) || err != nil {
+		t.Errorf("Read(), got %v, %v, want %v, nil", n, err, len(got))
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Reset(), got %v, want %v", got, want)
+	}
+}
+
+func TestClone(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	c.Read(make([]byte, 100))
+
+	d := c.Clone()
+	want := make([]byte, 100)
+	got := make([]byte, 100)
+	c.Read(want)
+	d.Read(got[:50])
+	d.Read(got[50:])
+	if !bytes.Equal(got, want) {
+		t.Errorf("Clone(), got %v, want %v", got, want)
+	}
+
+	// Advancing the clone leaves the original alone
+	e := c.Clone()
+	d.Seek(7)
+	c.Read(got)
+	e.Read(want)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Clone(), got %v, want %v", got, want)
+	}
+}
+
+func TestSubstream(t *testing.T) {
+	var key [32]byte
+	iv := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	c := New(key[:], iv, 12)
+	c.Read(make([]byte, 100))
+	before := c.Tell()
+
+	id := uint64(0x0102030405060708)
+	got := make([]byte, 200)
+	c.Substream(id).Read(got)
+	var want [8]byte
+	binary.LittleEndian.PutUint64(want[:], binary.LittleEndian.Uint64(iv)^id)
+	ref := make([]byte, 200)
+	New(key[:], want[:], 12).Read(ref)
+	if !bytes.Equal(got, ref) {
+		t.Errorf("Substream(%#x), got %x, want %x", id, got, ref)
+	}
+	if c.Tell() != before {
+		t.Errorf("Substream() moved the parent to %d, want %d", c.Tell(), before)
+	}
+
+	// Substreams are distinct from one another
+	seen := make(map[string]bool)
+	for id := uint64(0); id < 4; id++ {
+		block := make([]byte, 64)
+		c.Substream(id<<32 | id).Read(block)
+		if seen[string(block)] {
+			t.Errorf("Substream(%d) repeats another substream", id)
+		}
+		seen[string(block)] = true
+	}
+
+	// The IETF layout uses the last 8 bytes of the nonce
+	nonce := make([]byte, NonceSizeIETF)
+	d, _ := NewIETF(key[:], nonce, 20)
+	nonce[4] = 1
+	nonce[11] = 2
+	e, _ := NewIETF(key[:], nonce, 20)
+	d.Substream(2<<56 | 1).Read(got)
+	e.Read(ref)
+	if !bytes.Equal(got, ref) {
+		t.Errorf("Substream() IETF, got %x, want %x", got, ref)
+	}
+}
+
+func TestSeekByte(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	stream := make([]byte, 300)
+	c.Read(stream)
+
+	for _, off := range []int{0, 1, 63, 64, 65, 128, 250} {
+		c.SeekByte(uint64(off))
+		got := make([]byte, 50)
+		c.Read(got)
+		if !bytes.Equal(got, stream[off:off+50]) {
+			t.Errorf("SeekByte(%d), got %v, want %v",
+				off, got, stream[off:off+50])
+		}
+	}
+
+	// The largest byte offsets
+	c.Seek(0x3ffffffffffffff)
+	want := make([]byte, 64)
+	c.Read(want)
+	c.SeekByte(0xffffffffffffffc0)
+	got := make([]byte, 64)
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("SeekByte(), got %v, want %v", got, want)
+	}
+	c.SeekByte(0xffffffffffffffff)
+	c.Read(got[:1])
+	if got[0] != want[63] {
+		t.Errorf("SeekByte(), got %v, want %v", got[0], want[63])
+	}
+}
+
+func TestSeekRelative(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 1000)
+	c.Read(want)
+
+	c.Reset()
+	steps := []struct {
+		delta int64
+		pos   uint64
+	}{{100, 100}, {-37, 63}, {1, 64}, {0, 64}, {-64, 0}, {900, 900}}
+	for _, step := range steps {
+		if err := c.SeekRelative(step.delta); err != nil {
+			t.Fatal(err)
+		}
+		if c.Tell() != step.pos {
+			t.Errorf("SeekRelative(%d), got %v, want %v", step.delta, c.Tell(), step.pos)
+		}
+		b, _ := c.ReadByte()
+		if b != want[step.pos] {
+			t.Errorf("SeekRelative(%d), got byte %v, want %v", step.delta, b, want[step.pos])
+		}
+		c.SeekRelative(-1)
+	}
+
+	if err := c.SeekRelative(-901); err == nil || c.Tell() != 900 {
+		t.Errorf("SeekRelative(before start), got %v at %v", err, c.Tell())
+	}
+	if err := c.SeekRelative(math.MinInt64); err == nil || c.Tell() != 900 {
+		t.Errorf("SeekRelative(MinInt64), got %v at %v", err, c.Tell())
+	}
+
+	// Backward moves past 2^64 bytes fail rather than landing elsewhere
+	for _, block := range []uint64{1 << 58, math.MaxUint64} {
+		c.Seek(block)
+		c.Read(make([]byte, 10))
+		before := c.State()
+		if err := c.SeekRelative(-1); err != errSeekRange {
+			t.Errorf("SeekRelative(-1) at block %#x, got %v, want %v",
+				block, err, errSeekRange)
+		}
+		if c.State() != before || c.Tell() != block*64+10 {
+			t.Errorf("SeekRelative(-1) at block %#x moved the cipher", block)
+		}
+	}
+
+	d, _ := NewIETF(key[:], make([]byte, 12), 20)
+	d.Seek(0xffffffff)
+	if err := d.SeekRelative(65); err != ErrExhausted || d.Tell() != 1<<38-64 {
+		t.Errorf("SeekRelative(past end), got %v at %v", err, d.Tell())
+	}
+	if err := d.SeekRelative(64); err != nil || !d.Exhausted() {
+		t.Errorf("SeekRelative(to end), got %v, exhausted %v", err, d.Exhausted())
+	}
+	if err := d.SeekRelative(-10); err != nil || d.Remaining() != 10 {
+		t.Errorf("SeekRelative(back from end), got %v, remaining %v", err, d.Remaining())
+	}
+}
+
+func TestSeekTo(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 1000)
+	c.Read(want)
+
+	c.Reset()
+	r := c.ReadSeeker()
+	steps := []struct {
+		offset int64
+		whence int
+		pos    int64
+	}{
+		{500, io.SeekStart, 500},
+		{-100, io.SeekCurrent, 400},
+		{63, io.SeekStart, 63},
+		{0, io.SeekCurrent, 63},
+	}
+	for _, step := range steps {
+		pos, err := r.Seek(step.offset, step.whence)
+		if pos != step.pos || err != nil {
+			t.Errorf("Seek(%d, %d), got %v, %v, want %v, nil",
+				step.offset, step.whence, pos, err, step.pos)
+		}
+		got := make([]byte, 10)
+		io.ReadFull(r, got)
+		if !bytes.Equal(got, want[pos:pos+10]) {
+			t.Errorf("Seek(%d, %d), got %x, want %x",
+				step.offset, step.whence, got, want[pos:pos+10])
+		}
+		r.Seek(-10, io.SeekCurrent)
+	}
+
+	bad := []struct {
+		offset int64
+		whence int
+	}{
+		{-1, io.SeekStart},
+		{-64, io.SeekCurrent},
+		{0, io.SeekEnd},
+		{0, 3},
+	}
+	for _, b := range bad {
+		if _, err := c.SeekTo(b.offset, b.whence); err == nil || c.Tell() != 63 {
+			t.Errorf("SeekTo(%d, %d), got %v at %v", b.offset, b.whence, err, c.Tell())
+		}
+	}
+
+	// Past 2^64 bytes, Tell wraps, so relative seeks are out of range
+	for _, block := range []uint64{1 << 58, math.MaxUint64} {
+		c.Seek(block)
+		c.Read(make([]byte, 5))
+		before := c.State()
+		if _, err := c.SeekTo(0, io.SeekCurrent); err != errSeekRange {
+			t.Errorf("SeekTo(0, current) at block %#x, got %v, want %v",
+				block, err, errSeekRange)
+		}
+		if c.State() != before || c.Tell() != block*64+5 {
+			t.Errorf("SeekTo(0, current) at block %#x moved the cipher", block)
+		}
+	}
+	// IETF ciphers have a reachable end
+	d, _ := NewIETF(key[:], make([]byte, 12), 20)
+	if pos, err := d.SeekTo(-100, io.SeekEnd); pos != 1<<38-100 || err != nil {
+		t.Errorf("SeekTo(-100, end), got %v, %v", pos, err)
+	}
+	if n, err := io.ReadFull(d, make([]byte, 200)); n != 100 || err != io.ErrUnexpectedEOF {
+		t.Errorf("Read() near end, got %v, %v", n, err)
+	}
+	if pos, err := d.SeekTo(0, io.SeekEnd); pos != 1<<38 || err != nil || !d.Exhausted() {
+		t.Errorf("SeekTo(0, end), got %v, %v", pos, err)
+	}
+	if _, err := d.SeekTo(1, io.SeekEnd); err != ErrExhausted {
+		t.Errorf("SeekTo(1, end), got %v, want %v", err, ErrExhausted)
+	}
+	if _, err := d.SeekTo(1<<38+1, io.SeekStart); err != ErrExhausted {
+		t.Errorf("SeekTo(past end), got %v, want %v", err, ErrExhausted)
+	}
+}
+
+func TestTell(t *testing.T) {
+	var key [32]byte
+	var iv [12]byte
+	c := New(key[:], iv[:8], 20)
+
+	if got := c.Tell(); got != 0 {
+		t.Errorf("Tell(), got %v, want %v", got, 0)
+	}
+	c.Read(make([]byte, 100))
+	if got := c.Tell(); got != 100 {
+		t.Errorf("Tell(), got %v, want %v", got, 100)
+	}
+	c.Read(make([]byte, 28))
+	if got := c.Tell(); got != 128 {
+		t.Errorf("Tell(), got %v, want %v", got, 128)
+	}
+	c.Seek(5)
+	if got := c.Tell(); got != 320 {
+		t.Errorf("Tell(), got %v, want %v", got, 320)
+	}
+	c.SeekByte(1000)
+	if got := c.Tell(); got != 1000 {
+		t.Errorf("Tell(), got %v, want %v", got, 1000)
+	}
+
+	// A fully consumed IETF keystream ends at 2^38
+	c, _ = NewIETF(key[:], iv[:], 20)
+	c.Seek(0xffffffff)
+	c.Read(make([]byte, 64))
+	if got := c.Tell(); got != 1<<38 {
+		t.Errorf("Tell(), got %v, want %v", got, uint64(1)<<38)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	var key [32]byte
+	var iv [12]byte
+	c := New(key[:], iv[:8], 20)
+
+	if got := c.Remaining(); got != math.MaxUint64 {
+		t.Errorf("Remaining(), got %v, want %v", got, uint64(math.MaxUint64))
+	}
+	c.Seek(0xfffffffffffffffe)
+	c.Read(make([]byte, 10))
+	if got := c.Remaining(); got != 118 {
+		t.Errorf("Remaining(), got %v, want %v", got, 118)
+	}
+	c.Read(make([]byte, 100))
+	if got := c.Remaining(); got != 18 {
+		t.Errorf("Remaining(), got %v, want %v", got, 18)
+	}
+	c.Read(make([]byte, 100))
+	if got := c.Remaining(); got != 0 {
+		t.Errorf("Remaining(), got %v, want %v", got, 0)
+	}
+
+	c, _ = NewIETF(key[:], iv[:], 20)
+	if got := c.Remaining(); got != 1<<38 {
+		t.Errorf("Remaining(), got %v, want %v", got, uint64(1)<<38)
+	}
+	c.Read(make([]byte, 1))
+	if got := c.Remaining(); got != 1<<38-1 {
+		t.Errorf("Remaining(), got %v, want %v", got, uint64(1)<<38-1)
+	}
+}
+
+func TestExhausted(t *testing.T) {
+	var key [32]byte
+	var nonce [12]byte
+	ietf, _ := NewIETF(key[:], nonce[:], 20)
+	for _, c := range []*Cipher{New(key[:], nonce[:8], 20), ietf} {
+		if c.Exhausted() {
+			t.Errorf("Exhausted() on new cipher, got true")
+		}
+		c.Seek(c.Counter() - 2) // second-to-last block
+		c.Read(make([]byte, 127))
+		if c.Exhausted() {
+			t.Errorf("Exhausted() with 1 byte left, got true")
+		}
+		c.Read(make([]byte, 1))
+		if !c.Exhausted() || c.Remaining() != 0 {
+			t.Errorf("Exhausted() at end, got false")
+		}
+		c.Reset()
+		if c.Exhausted() {
+			t.Errorf("Exhausted() after Reset(), got true")
+		}
+	}
+}
+
+func TestZeroize(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	var iv [8]byte
+	c := New(key, iv[:], 20)
+	c.Read(make([]byte, 10))
+
+	c.Zeroize()
+	if c.input != [16]uint32{} || c.output != [64]byte{} {
+		t.Errorf("Zeroize() left state behind")
+	}
+	if n, err := c.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("Read(), got %v, %v, want 0, %v", n, err, io.EOF)
+	}
+
+	// Repositioning does not revive the all-zero state, whose keystream
+	// would be public
+	pos := New(key, iv[:], 20).Position()
+	moves := []func(){
+		c.Reset,
+		func() { c.Seek(3) },
+		func() { c.SeekByte(100) },
+		func() { c.SetCounter(0) },
+		func() { c.Skip(10) },
+		func() { c.SetNonce(iv[:]) },
+		func() { c.SetWrapOnOverflow(true) },
+		func() { c.Restore(pos) },
+	}
+	for i, move := range moves {
+		move()
+		if n, err := c.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+			t.Errorf("Read() after move %d, got %v, %v, want 0, %v", i, n, err, io.EOF)
+		}
+		if c.Remaining() != 0 || !c.Exhausted() {
+			t.Errorf("move %d revived the cipher", i)
+		}
+		msg := []byte("plaintext")
+		func() {
+			defer func() { recover() }()
+			c.XORKeyStream(msg, msg)
+		}()
+		if string(msg) != "plaintext" {
+			t.Errorf("XORKeyStream() after move %d wrote %q", i, msg)
+		}
+	}
+
+	// Rekey makes it usable again
+	if err := c.Rekey(key, iv[:], 20); err != nil || c.Exhausted() {
+		t.Errorf("Rekey() after Zeroize(), got %v, exhausted %v", err, c.Exhausted())
+	}
+}
+
+func TestKey128(t *testing.T) {
+	// draft-strombergson-chacha-test-vectors, TC1 (128-bit key)
+	var key [16]byte
+	var iv [8]byte
+	tests := []struct {
+		rounds int
+		want   []byte
+	}{
+		{8, []byte{
+			0xe2, 0x8a, 0x5f, 0xa4, 0xa6, 0x7f, 0x8c, 0x5d,
+			0xef, 0xed, 0x3e, 0x6f, 0xb7, 0x30, 0x34, 0x86,
+			0xaa, 0x84, 0x27, 0xd3, 0x14, 0x19, 0xa7, 0x29,
+			0x57, 0x2d, 0x77, 0x79, 0x53, 0x49, 0x11, 0x20,
+			0xb6, 0x4a, 0xb8, 0xe7, 0x2b, 0x8d, 0xeb, 0x85,
+			0xcd, 0x6a, 0xea, 0x7c, 0xb6, 0x08, 0x9a, 0x10,
+			0x18, 0x24, 0xbe, 0xeb, 0x08, 0x81, 0x4a, 0x42,
+			0x8a, 0xab, 0x1f, 0xa2, 0xc8, 0x16, 0x08, 0x1b,
+		}},
+		{20, []byte{
+			0x89, 0x67, 0x09, 0x52, 0x60, 0x83, 0x64, 0xfd,
+			0x00, 0xb2, 0xf9, 0x09, 0x36, 0xf0, 0x31, 0xc8,
+			0xe7, 0x56, 0xe1, 0x5d, 0xba, 0x04, 0xb8, 0x49,
+			0x3d, 0x00, 0x42, 0x92, 0x59, 0xb2, 0x0f, 0x46,
+			0xcc, 0x04, 0xf1, 0x11, 0x24, 0x6b, 0x6c, 0x2c,
+			0xe0, 0x66, 0xbe, 0x3b, 0xfb, 0x32, 0xd9, 0xaa,
+			0x0f, 0xdd, 0xfb, 0xc1, 0x21, 0x23, 0xd4, 0xb9,
+			0xe4, 0x4f, 0x34, 0xdc, 0xa0, 0x5a, 0x10, 0x3f,
+		}},
+	}
+	for _, test := range tests {
+		c, err := NewKey128(key[:], iv[:], test.rounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 64)
+		c.Read(got)
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("NewKey128(%d rounds), got %x, want %x",
+				test.rounds, got, test.want)
+		}
+	}
+
+	if _, err := NewKey128(make([]byte, 32), iv[:], 20); err != ErrKeySize {
+		t.Errorf("NewKey128(32-byte key), got %v, want %v", err, ErrKeySize)
+	}
+}
+
+func TestKnownAnswers(t *testing.T) {
+	// draft-strombergson-chacha-test-vectors, TC1 and TC8 (256-bit key),
+	// with the second block of TC1 reached by seeking
+	const (
+		zeroKey = "0000000000000000000000000000000000000000000000000000000000000000"
+		zeroIV  = "0000000000000000"
+		tc8Key  = "c46ec1b18ce8a878725a37e780dfb7351f68ed2e194c79fbc6aebee1a667975d"
+		tc8IV   = "1ada31d5cf688221"
+	)
+	tests := []struct {
+		key, iv string
+		rounds  int
+		block   uint64
+		want    string
+	}{
+		{zeroKey, zeroIV, 8, 0, "" +
+			"3e00ef2f895f40d67f5bb8e81f09a5a12c840ec3ce9a7f3b181be188ef711a1e" +
+			"984ce172b9216f419f445367456d5619314a42a3da86b001387bfdb80e0cfe42"},
+		{zeroKey, zeroIV, 8, 1, "" +
+			"d2aefa0deaa5c151bf0adb6c01f2a5adc0fd581259f9a2aadcf20f8fd566a26b" +
+			"5032ec38bbc5da98ee0c6f568b872a65a08abf251deb21bb4b56e5d8821e68aa"},
+		{zeroKey, zeroIV, 12, 0, "" +
+			"9bf49a6a0755f953811fce125f2683d50429c3bb49e074147e0089a52eae155f" +
+			"0564f879d27ae3c02ce82834acfa8c793a629f2ca0de6919610be82f411326be"},
+		{zeroKey, zeroIV, 12, 1, "" +
+			"0bd58841203e74fe86fc71338ce0173dc628ebb719bdcbcc151585214cc089b4" +
+			"42258dcda14cf111c602b8971b8cc843e91e46ca905151c02744a6b017e69316"},
+		{zeroKey, zeroIV, 20, 0, "" +
+			"76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7" +
+			"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586"},
+		{zeroKey, zeroIV, 20, 1, "" +
+			"9f07e7be5551387a98ba977c732d080dcb0f29a048e3656912c6533e32ee7aed" +
+			"29b721769ce64e43d57133b074d839d531ed1f28510afb45ace10a1f4b794d6f"},
+		{tc8Key, tc8IV, 8, 0, "" +
+			"838751b42d8ddd8a3d77f48825a2ba752cf4047cb308a5978ef274973be374c9" +
+			"6ad848065871417b08f034e681fe46a93f7d5c61d1306614d4aaf257a7cff08b"},
+		{tc8Key, tc8IV, 12, 0, "" +
+			"1482072784bc6d06b4e73bdc118bc0103c7976786ca918e06986aa251f7e9cc1" +
+			"b2749a0a16ee83b4242d2e99b08d7c20092b80bc466c87283b61b1b39d0ffbab"},
+		{tc8Key, tc8IV, 20, 0, "" +
+			"f63a89b75c2271f9368816542ba52f06ed49241792302b00b5e8f80ae9a473af" +
+			"c25b218f519af0fdd406362e8d69de7f54c604a6e00f353f110f771bdca8ab92"},
+	}
+	for _, test := range tests {
+		key, _ := hex.DecodeString(test.key)
+		iv, _ := hex.DecodeString(test.iv)
+		want, _ := hex.DecodeString(test.want)
+		c := New(key, iv, test.rounds)
+		c.Seek(test.block)
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("ChaCha%d(%s, %s) block %d, got %x, want %x",
+				test.rounds, test.key, test.iv, test.block, got, want)
+		}
+	}
+}
+
+func TestKnownAnswersDJB(t *testing.T) {
+	// draft-agl-tls-chacha20poly1305, section 7, which uses the original
+	// 8-byte nonce and 64-bit counter, as do DJB's reference code and
+	// libsodium's crypto_stream_chacha20. The final vector spans four
+	// blocks, so its later blocks are also checked by seeking.
+	const (
+		zeroKey = "0000000000000000000000000000000000000000000000000000000000000000"
+		oneKey  = "0000000000000000000000000000000000000000000000000000000000000001"
+		seqKey  = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+		seqOut  = "" +
+			"f798a189f195e66982105ffb640bb7757f579da31602fc93ec01ac56f85ac3c1" +
+			"34a4547b733b46413042c9440049176905d3be59ea1c53f15916155c2be8241a" +
+			"38008b9a26bc35941e2444177c8ade6689de95264986d95889fb60e84629c9bd" +
+			"9a5acb1cc118be563eb9b3a4a472f82e09a7e778492b562ef7130e88dfe031c7" +
+			"9db9d4f7c7a899151b9a475032b63fc385245fe054e3dd5a97a5f576fe064025" +
+			"d3ce042c566ab2c507b138db853e3d6959660996546cc9c4a6eafdc777c040d7" +
+			"0eaf46f76dad3979e5c5360c3317166a1c894c94a371876a94df7628fe4eaaf2" +
+			"ccb27d5aaae0ad7ad0f9d4b6ad3b54098746d4524d38407a6deb3ab78fab78c9"
+	)
+	tests := []struct {
+		key, nonce string
+		block      uint64
+		want       string
+	}{
+		{zeroKey, "0000000000000000", 0, "" +
+			"76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7" +
+			"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586"},
+		{oneKey, "0000000000000000", 0, "" +
+			"4540f05a9f1fb296d7736e7b208e3c96eb4fe1834688d2604f450952ed432d41" +
+			"bbe2a0b6ea7566d2a5d1e7e20d42af2c53d792b1c43fea817e9ad275ae546963"},
+		{zeroKey, "0000000000000001", 0, "" +
+			"de9cba7bf3d69ef5e786dc63973f653a0b49e015adbff7134fcb7df137821031" +
+			"e85a050278a7084527214f73efc7fa5b5277062eb7a0433e445f41e3"},
+		{zeroKey, "0100000000000000", 0, "" +
+			"ef3fdfd6c61578fbf5cf35bd3dd33b8009631634d21e42ac33960bd138e50d32" +
+			"111e4caf237ee53ca8ad6426194a88545ddc497a0b466e7d6bbdb0041b2f586b"},
+		{seqKey, "0001020304050607", 0, seqOut},
+		{seqKey, "0001020304050607", 1, seqOut[128:]},
+		{seqKey, "0001020304050607", 3, seqOut[384:]},
+	}
+	for _, test := range tests {
+		key, _ := hex.DecodeString(test.key)
+		nonce, _ := hex.DecodeString(test.nonce)
+		want, _ := hex.DecodeString(test.want)
+		c := New(key, nonce, 20)
+		c.Seek(test.block)
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("ChaCha20(%s, %s) block %d, got %x, want %x",
+				test.key, test.nonce, test.block, got, want)
+		}
+	}
+}
+
+func TestSetCounter(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	c.Seek(3)
+	want := make([]byte, 64)
+	c.Read(want)
+
+	c.Seek(9)
+	c.Read(make([]byte, 10))
+	c.SetCounter(3)
+	if got := c.Counter(); got != 3 {
+		t.Errorf("Counter(), got %v, want %v", got, 3)
+	}
+	got := make([]byte, 64)
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("SetCounter(), got %v, want %v", got, want)
+	}
+	if got := c.Counter(); got != 4 {
+		t.Errorf("Counter(), got %v, want %v", got, 4)
+	}
+
+	// Clears exhaustion
+	c.Seek(0xffffffffffffffff)
+	c.Read(make([]byte, 64))
+	c.SetCounter(3)
+	if n, err := c.Read(got); n != 64 || err != nil {
+		t.Errorf("Read(), got %v, %v, want 64, nil", n, err)
+	}
+}
+
+func TestCounterCarry(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	c.Seek(0xffffffff)
+	c.Read(make([]byte, 64))
+	if c.input[12] != 0 || c.input[13] != 1 {
+		t.Errorf("counter after carry, got %#x, %#x, want 0, 1",
+			c.input[12], c.input[13])
+	}
+	got := make([]byte, 64)
+	c.Read(got)
+	want := make([]byte, 64)
+	d := New(key[:], iv[:], 20)
+	d.Seek(0x100000000)
+	d.Read(want)
+	if !bytes.Equal(got, want) {
+		t.Errorf("block 2^32, got %x, want %x", got, want)
+	}
+
+	// Bulk generation across the carry, through any vector core
+	c.Seek(0xfffffffd)
+	bulk := make([]byte, 8*64)
+	c.Read(bulk)
+	for i := 0; i < 8; i++ {
+		d.Seek(0xfffffffd + uint64(i))
+		d.Read(want)
+		if !bytes.Equal(bulk[i*64:(i+1)*64], want) {
+			t.Errorf("bulk block %#x, got %x, want %x",
+				0xfffffffd+uint64(i), bulk[i*64:(i+1)*64], want)
+		}
+	}
+}
+
+func TestXORKeyStreamLengths(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 10)
+	c.XORKeyStream(want, want)
+
+	// A longer dst is fine, and only len(src) bytes are written
+	c.Reset()
+	got := make([]byte, 20)
+	c.XORKeyStream(got, make([]byte, 10))
+	if !bytes.Equal(got[:10], want) || !bytes.Equal(got[10:], make([]byte, 10)) {
+		t.Errorf("XORKeyStream(), got %v, want %v", got, want)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("XORKeyStream(short dst) did not panic")
+			}
+		}()
+		c.XORKeyStream(make([]byte, 5), make([]byte, 10))
+	}()
+}
+
+func TestXORKeyStreamAllocs(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	buf := make([]byte, 4096+37)
+	for _, n := range []int{1, 63, 64, 100, len(buf)} {
+		allocs := testing.AllocsPerRun(100, func() {
+			c.XORKeyStream(buf[:n], buf[:n])
+		})
+		if allocs != 0 {
+			t.Errorf("XORKeyStream(%d bytes), got %v allocs, want 0", n, allocs)
+		}
+	}
+}
+
+func TestXORKeyStreamOverlap(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 100)
+	c.XORKeyStream(want, want)
+
+	// Exact overlap (in-place) is allowed
+	c.Reset()
+	buf := make([]byte, 100)
+	c.XORKeyStream(buf, buf)
+	if !bytes.Equal(buf, want) {
+		t.Errorf("XORKeyStream(in-place), got %v, want %v", buf, want)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("XORKeyStream(partial overlap) did not panic")
+			}
+		}()
+		c.XORKeyStream(buf[1:], buf[:99])
+	}()
+}
+
+func TestWriteTo(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	c.Seek(0xfffffffffffffe00)
+	c.Read(make([]byte, 7))
+	want := make([]byte, 1000*64)
+	n, _ := c.Clone().Read(want)
+	want = want[:n]
+
+	var buf bytes.Buffer
+	total, err := io.Copy(&buf, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != int64(len(want)) {
+		t.Errorf("WriteTo(), got %v, want %v", total, len(want))
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WriteTo(), wrong keystream")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	for _, wrap := range []bool{false, true} {
+		c := New(key[:], iv[:], 12)
+		c.SetWrapOnOverflow(wrap)
+		c.Seek(math.MaxUint64 - 2)
+		c.Read(make([]byte, 10))
+		want := make([]byte, 3*64-10)
+		c.Clone().Read(want)
+
+		var buf bytes.Buffer
+		n, err := c.Drain(&buf)
+		if n != int64(len(want)) || err != nil {
+			t.Errorf("Drain(), got %v, %v, want %v, nil", n, err, len(want))
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("Drain(), wrong keystream")
+		}
+		if c.Exhausted() == wrap {
+			t.Errorf("Exhausted() after Drain() with wrap %v, got %v",
+				wrap, c.Exhausted())
+		}
+		if wrap && c.Tell() != 0 {
+			t.Errorf("Tell() after Drain() with wrap, got %v, want 0", c.Tell())
+		}
+	}
+
+	c := New(key[:], iv[:], 20)
+	c.Seek(math.MaxUint64 - 10)
+	lw := &limitedWriter{limit: 100}
+	if n, err := c.Drain(lw); n != 100 || err != io.ErrClosedPipe {
+		t.Errorf("Drain(short writer), got %v, %v", n, err)
+	}
+}
+
+// Cancels a context after a number of writes.
+type cancelWriter struct {
+	n      int
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	if w.n--; w.n == 0 {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+func TestWriteToContext(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	c.SetWrapOnOverflow(true) // never ends on its own
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &cancelWriter{n: 3, cancel: cancel}
+	total, err := c.WriteToContext(ctx, w)
+	if err != context.Canceled {
+		t.Errorf("WriteToContext(), got %v, want %v", err, context.Canceled)
+	}
+	if total != 3*16<<10 || c.Tell() != uint64(total) {
+		t.Errorf("WriteToContext(), got %v bytes at %v, want %v",
+			total, c.Tell(), 3*16<<10)
+	}
+
+	// An already cancelled context writes nothing
+	if total, err := c.WriteToContext(ctx, w); total != 0 || err == nil {
+		t.Errorf("WriteToContext(cancelled), got %v, %v, want 0, error",
+			total, err)
+	}
+}
+
+func TestSeekBlock(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 64)
+	c.Seek(3)
+	c.Read(want)
+
+	for _, skip := range []int{0, 1, 63, 64} {
+		c.Seek(3)
+		c.Read(make([]byte, skip))
+		prev := c.SeekBlock(100)
+		if wantPrev := uint64(3 + skip/64); prev != wantPrev {
+			t.Errorf("SeekBlock() after %d bytes, got %v, want %v",
+				skip, prev, wantPrev)
+		}
+		if c.Tell() != 100*64 {
+			t.Errorf("Tell(), got %v, want %v", c.Tell(), 100*64)
+		}
+	}
+
+	// Seek away and back again
+	c.Seek(3)
+	prev := c.SeekBlock(50)
+	c.Read(make([]byte, 10))
+	c.SeekBlock(prev)
+	got := make([]byte, 64)
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("SeekBlock() round trip, got %v, want %v", got, want)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	var key [32]byte
+	var nonce [12]byte
+	ietf, _ := NewIETF(key[:], nonce[:], 20)
+	for _, c := range []*Cipher{New(key[:], nonce[:8], 20), ietf} {
+		for _, skip := range []int{0, 1, 63, 64, 65, 1000} {
+			for _, advance := range []int{0, 1, 64, 500} {
+				c.Reset()
+				c.Read(make([]byte, skip))
+				pos := c.Position()
+				want := make([]byte, 100)
+				c.Read(want)
+
+				c.Restore(pos)
+				c.Read(make([]byte, advance))
+				c.Restore(pos)
+				got := make([]byte, 100)
+				c.Read(got)
+				if !bytes.Equal(got, want) {
+					t.Errorf("Restore() after %d, %d, got %v, want %v",
+						skip, advance, got, want)
+				}
+			}
+		}
+
+		// Exhaustion is part of the position
+		c.Seek(math.MaxUint64 - 1) // second-to-last block, either layout
+		c.Read(make([]byte, 100))
+		pos := c.Position()
+		c.Read(make([]byte, 28))
+		end := c.Position()
+		c.Reset()
+		c.Restore(end)
+		if n, err := c.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Errorf("Read() after Restore(end), got %v, %v, want 0, EOF", n, err)
+		}
+		c.Restore(pos)
+		if n, _ := c.Read(make([]byte, 100)); n != 28 {
+			t.Errorf("Read() after Restore(), got %v, want 28", n)
+		}
+	}
+}
+
+func TestSeekErr(t *testing.T) {
+	var key [32]byte
+	var nonce [12]byte
+	c, _ := NewIETF(key[:], nonce[:], 20)
+
+	if err := c.SeekErr(0xffffffff); err != nil {
+		t.Errorf("SeekErr(0xffffffff), got %v, want nil", err)
+	}
+	if n, err := c.Read(make([]byte, 100)); n != 64 || err != io.EOF {
+		t.Errorf("Read(), got %v, %v, want 64, %v", n, err, io.EOF)
+	}
+
+	c.Seek(5)
+	want := c.Tell()
+	if err := c.SeekErr(0x100000000); err != ErrExhausted {
+		t.Errorf("SeekErr(0x100000000), got %v, want %v", err, ErrExhausted)
+	}
+	if got := c.Tell(); got != want {
+		t.Errorf("Tell() after failed SeekErr(), got %v, want %v", got, want)
+	}
+
+	c = New(key[:], nonce[:8], 20)
+	if err := c.SeekErr(0xffffffffffffffff); err != nil {
+		t.Errorf("SeekErr(max), got %v, want nil", err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	key := make([]byte, 32)
+	iv := make([]byte, 8)
+	var nonce [12]byte
+	c, _ := NewIETF(key, nonce[:], 8)
+	c.Read(make([]byte, 100))
+
+	key[0] = 1
+	iv[0] = 2
+	if err := c.Rekey(key, iv, 20); err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 200)
+	New(key, iv, 20).Read(want)
+	got := make([]byte, 200)
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("Rekey(), got %v, want %v", got, want)
+	}
+
+	if err := c.Rekey(key[:16], iv, 20); err != ErrKeySize {
+		t.Errorf("Rekey(short key), got %v, want %v", err, ErrKeySize)
+	}
+	if allocs := testing.AllocsPerRun(10, func() {
+		c.Rekey(key, iv, 20)
+	}); allocs != 0 {
+		t.Errorf("Rekey(), got %v allocations, want 0", allocs)
+	}
+}
+
+func TestSetNonce(t *testing.T) {
+	key := make([]byte, 32)
+	key[0] = 1
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+	c := New(key, make([]byte, 8), 20)
+	c.Seek(0xffffffffffffffff)
+	c.Read(make([]byte, 100))
+	if err := c.SetNonce(nonce[:8]); err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 100)
+	New(key, nonce[:8], 20).Read(want)
+	got := make([]byte, 100)
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("SetNonce(), got %v, want %v", got, want)
+	}
+	if err := c.SetNonce(nonce); err != ErrNonceSize {
+		t.Errorf("SetNonce(long nonce), got %v, want %v", err, ErrNonceSize)
+	}
+	x, _ := NewX(key, make([]byte, 24), 20)
+	if err := x.SetNonce(make([]byte, 24)); err != ErrNonceSize {
+		t.Errorf("SetNonce(XChaCha nonce), got %v, want %v", err, ErrNonceSize)
+	}
+
+	c, _ = NewIETF(key, make([]byte, 12), 20)
+	c.Read(make([]byte, 10))
+	if err := c.SetNonce(nonce[:8]); err != ErrNonceSize {
+		t.Errorf("SetNonce(short nonce), got %v, want %v", err, ErrNonceSize)
+	}
+	if err := c.SetNonce(append(nonce, 0)); err != ErrNonceSize {
+		t.Errorf("SetNonce(long nonce), got %v, want %v", err, ErrNonceSize)
+	}
+	if err := c.SetNonce(nonce); err != nil {
+		t.Fatal(err)
+	}
+	ietf, _ := NewIETF(key, nonce, 20)
+	ietf.Read(want)
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("SetNonce(IETF), got %v, want %v", got, want)
+	}
+}
+
+func TestXORAt(t *testing.T) {
+	var key [32]byte
+	var nonce [12]byte
+	c, _ := NewIETF(key[:], nonce[:], 20)
+	want := make([]byte, 1000)
+	c.Read(want)
+
+	c.Reset()
+	c.Read(make([]byte, 10))
+	saved := *c
+	for _, offset := range []int{0, 1, 64, 100, 500} {
+		got := make([]byte, 300)
+		c.XORAt(got, got, uint64(offset))
+		if !bytes.Equal(got, want[offset:offset+300]) {
+			t.Errorf("XORAt(%d), got %v, want %v", offset, got, want[offset:offset+300])
+		}
+		if *c != saved {
+			t.Errorf("XORAt(%d) changed the cipher", offset)
+		}
+	}
+	if allocs := testing.AllocsPerRun(10, func() {
+		c.XORAt(want, want, 100)
+	}); allocs != 0 {
+		t.Errorf("XORAt(), got %v allocations, want 0", allocs)
+	}
+
+	// Offsets past the end of the keystream
+	func() {
+		defer func() {
+			if r := recover(); r != ErrExhausted {
+				t.Errorf("XORAt(past end), got panic %v, want %v", r, ErrExhausted)
+			}
+		}()
+		c.XORAt(want[:1], want[:1], 1<<38)
+	}()
+}
+
+func TestXORInPlace(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	plain := []byte("attack at dawn, attack at dawn, attack at dawn, attack at dawn!!")
+	want := make([]byte, len(plain))
+	c.XORKeyStream(want, plain)
+
+	c.Reset()
+	buf := append([]byte(nil), plain...)
+	c.XORInPlace(buf)
+	if !bytes.Equal(buf, want) {
+		t.Errorf("XORInPlace(), got %v, want %v", buf, want)
+	}
+	c.Reset()
+	c.XORInPlace(buf)
+	if !bytes.Equal(buf, plain) {
+		t.Errorf("XORInPlace(), got %q, want %q", buf, plain)
+	}
+}
+
+func TestUnbuffered(t *testing.T) {
+	key := make([]byte, 32)
+	iv := make([]byte, 8)
+	src := make([]byte, 20*64)
+	for i := range src {
+		src[i] = byte(i)
+	}
+	want := make([]byte, len(src))
+	New(key, iv, 20).XORKeyStream(want, src)
+
+	c, err := NewUnbuffered(key, iv, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(src))
+	c.XORKeyStream(got[:64], src[:64])
+	c.XORKeyStream(got[64:], src[64:])
+	if !bytes.Equal(got, want) {
+		t.Errorf("XORKeyStream(), got %x, want %x", got, want)
+	}
+	if c.output != [BlockSize]byte{} {
+		t.Errorf("XORKeyStream() left keystream buffered")
+	}
+
+	// In place, after seeking
+	c.Seek(3)
+	copy(got, src)
+	c.XORKeyStream(got[3*64:], got[3*64:])
+	if !bytes.Equal(got[3*64:], want[3*64:]) {
+		t.Errorf("XORKeyStream() in place, got %x, want %x", got, want)
+	}
+	c.SeekByte(64)
+	ks := make([]byte, 128)
+	if n, err := c.Read(ks); n != 128 || err != nil {
+		t.Errorf("Read(), got %v, %v", n, err)
+	}
+	for i := range ks {
+		if ks[i] != want[64+i]^src[64+i] {
+			t.Fatalf("Read(), wrong keystream at %d", i)
+		}
+	}
+
+	// Exhaustion is unchanged
+	c.Seek(math.MaxUint64)
+	if n, err := c.Read(make([]byte, 128)); n != 64 || err != io.EOF {
+		t.Errorf("Read() at end, got %v, %v, want 64, %v", n, err, io.EOF)
+	}
+
+	// Anything unaligned panics before changing the cipher
+	c.Reset()
+	unaligned := []func(){
+		func() { c.XORKeyStream(got[:65], src[:65]) },
+		func() { c.Read(got[:10]) },
+		func() { c.ReadByte() },
+		func() { c.SeekByte(100) },
+		func() { c.Skip(1) },
+		func() { c.XORAt(got[:64], src[:64], 1) },
+	}
+	for i, f := range unaligned {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrUnaligned {
+					t.Errorf("unaligned op %d, got panic %v, want %v",
+						i, r, ErrUnaligned)
+				}
+			}()
+			f()
+		}()
+		if c.Tell() != 0 {
+			t.Errorf("unaligned op %d moved the cipher to %v", i, c.Tell())
+		}
+	}
+
+	// Byte-offset seeks report misalignment without moving the cipher
+	c.Seek(2)
+	if _, err := c.SeekTo(100, io.SeekStart); err != ErrUnaligned {
+		t.Errorf("SeekTo(100), got %v, want %v", err, ErrUnaligned)
+	}
+	for _, delta := range []int64{1, -1, -65} {
+		if err := c.SeekRelative(delta); err != ErrUnaligned {
+			t.Errorf("SeekRelative(%d), got %v, want %v", delta, err, ErrUnaligned)
+		}
+	}
+	if c.Tell() != 128 {
+		t.Errorf("unaligned seeks moved the cipher to %v", c.Tell())
+	}
+	if off, err := c.SeekTo(-64, io.SeekCurrent); off != 64 || err != nil {
+		t.Errorf("SeekTo(-64, current), got %v, %v, want 64, nil", off, err)
+	}
+
+	state, _ := c.MarshalBinary()
+	var d Cipher
+	if err := d.UnmarshalBinary(state); err != nil || !d.unbuf {
+		t.Errorf("UnmarshalBinary(), got %v, unbuffered %v", err, d.unbuf)
+	}
+}
+
+func TestCombineXOR(t *testing.T) {
+	var key [32]byte
+	iv1 := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+	iv2 := []byte{2, 0, 0, 0, 0, 0, 0, 0}
+	want := make([]byte, 300)
+	tmp := make([]byte, len(want))
+	New(key[:], iv1, 20).KeyStream(want)
+	b := New(key[:], iv2, 12)
+	b.Read(make([]byte, 5))
+	b.KeyStream(tmp)
+	subtle.XORBytes(want, want, tmp)
+
+	a := New(key[:], iv1, 20)
+	b = New(key[:], iv2, 12)
+	b.Read(make([]byte, 5))
+	got := make([]byte, len(want))
+	CombineXOR(got[:7], a, b)
+	CombineXOR(got[7:], a, b)
+	if !bytes.Equal(got, want) {
+		t.Errorf("CombineXOR(), got %x, want %x", got, want)
+	}
+	if a.Tell() != 300 || b.Tell() != 305 {
+		t.Errorf("CombineXOR() positions, got %v, %v, want 300, 305",
+			a.Tell(), b.Tell())
+	}
+
+	// Neither cipher advances when one would be exhausted
+	b.Seek(math.MaxUint64)
+	func() {
+		defer func() {
+			if r := recover(); r != ErrExhausted {
+				t.Errorf("CombineXOR(), got panic %v, want %v", r, ErrExhausted)
+			}
+		}()
+		CombineXOR(got[:65], a, b)
+	}()
+	if a.Tell() != 300 {
+		t.Errorf("CombineXOR() advanced on panic to %v", a.Tell())
+	}
+}
+
+func TestWrapOnOverflow(t *testing.T) {
+	var key [32]byte
+	var nonce [12]byte
+	for _, ietf := range []bool{false, true} {
+		var c *Cipher
+		if ietf {
+			c, _ = NewIETF(key[:], nonce[:], 20)
+		} else {
+			c = New(key[:], nonce[:8], 20)
+		}
+		want := make([]byte, 100)
+		c.Read(want)
+
+		c.SetWrapOnOverflow(true)
+		if ietf {
+			c.Seek(0xffffffff)
+		} else {
+			c.Seek(0xffffffffffffffff)
+		}
+		c.Read(make([]byte, 60))
+		end := c.Tell() + 4
+		if got := c.Remaining(); got != math.MaxUint64 {
+			t.Errorf("Remaining(), got %v, want %v", got, uint64(math.MaxUint64))
+		}
+		got := make([]byte, 104)
+		if n, err := c.Read(got); n != len(got) || err != nil {
+			t.Errorf("Read(), got %v, %v, want %v, nil", n, err, len(got))
+		}
+		if !bytes.Equal(got[4:], want) {
+			t.Errorf("Read() after wrap, got %v, want %v", got[4:], want)
+		}
+		if ietf && end != 1<<38 {
+			t.Errorf("Tell(), got %v, want %v", end, uint64(1)<<38)
+		}
+		if got := c.Tell(); got != 100 {
+			t.Errorf("Tell(), got %v, want %v", got, 100)
+		}
+
+		// Resumes an exhausted cipher
+		c.SetWrapOnOverflow(false)
+		if ietf {
+			c.Seek(0xfffffffe)
+		} else {
+			c.Seek(0xfffffffffffffffe)
+		}
+		c.Read(make([]byte, 200))
+		c.SetWrapOnOverflow(true)
+		c.Read(got[:100])
+		if !bytes.Equal(got[:100], want) {
+			t.Errorf("Read() after resume, got %v, want %v", got[:100], want)
+		}
+	}
+}
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestReaderWriter(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	plaintext := make([]byte, 1000)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	want := make([]byte, len(plaintext))
+	New(key[:], iv[:], 20).XORKeyStream(want, plaintext)
+
+	var buf closeRecorder
+	w := New(key[:], iv[:], 20).Writer(&buf)
+	w.Write(plaintext[:100])
+	w.Write(plaintext[100:])
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.closed {
+		t.Errorf("Writer().Close() closed the underlying writer")
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("Writer(), got %v, want %v", buf.Bytes(), want)
+	}
+
+	r := New(key[:], iv[:], 20).Reader(&buf.Buffer)
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Reader(), got %v, want %v", got, plaintext)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	var key [32]byte
+	var nonce [12]byte
+	ietf, _ := NewIETF(key[:], nonce[:], 20)
+	for _, c := range []*Cipher{New(key[:], nonce[:8], 20), ietf} {
+		c.Reset()
+		want := make([]byte, 1000)
+		c.Read(want)
+
+		for _, start := range []int{0, 1, 63, 64, 100} {
+			for _, skip := range []int{0, 1, 27, 63, 64, 65, 128, 500} {
+				c.Reset()
+				c.Read(make([]byte, start))
+				c.Skip(uint64(skip))
+				got := make([]byte, 100)
+				c.Read(got)
+				off := start + skip
+				if !bytes.Equal(got, want[off:off+100]) {
+					t.Errorf("Skip(%d) after %d, got %v, want %v",
+						skip, start, got, want[off:off+100])
+				}
+			}
+		}
+
+		// Skipping to the end exhausts the cipher
+		last := uint64(math.MaxUint64)
+		if c == ietf {
+			last = math.MaxUint32
+		}
+		c.Seek(last - 1)
+		c.Read(make([]byte, 10))
+		rem := c.Remaining()
+		for _, skip := range []uint64{rem - 1, rem, rem + 1, math.MaxUint64} {
+			c.Seek(last - 1)
+			c.Read(make([]byte, 10))
+			c.Skip(skip)
+			want := 0
+			if skip < rem {
+				want = int(rem - skip)
+			}
+			n, _ := c.Read(make([]byte, 2))
+			if n != want || c.Remaining() != 0 {
+				t.Errorf("Read() after Skip(%d), got %v, want %v",
+					skip, n, want)
+			}
+		}
+	}
+
+	// Skipping 2^64 - 1 bytes of a fresh cipher leaves most of the 2^70
+	// byte keystream, even though Remaining saturates
+	c := New(key[:], nonce[:8], 20)
+	c.Skip(math.MaxUint64)
+	if c.Exhausted() || c.Tell() != math.MaxUint64 {
+		t.Errorf("Skip(MaxUint64), got Tell() %#x, Exhausted() %v",
+			c.Tell(), c.Exhausted())
+	}
+	d := New(key[:], nonce[:8], 20)
+	d.Seek(1<<58 - 1)
+	want := make([]byte, 65)
+	d.Read(want)
+	got := make([]byte, 2)
+	if _, err := c.Read(got); err != nil || !bytes.Equal(got, want[63:]) {
+		t.Errorf("Read() after Skip(MaxUint64), got %x, %v, want %x",
+			got, err, want[63:])
+	}
+}
+
+// Accepts at most limit bytes in total, then fails.
+type limitedWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		p = p[:w.limit]
+	}
+	n, _ := w.Buffer.Write(p)
+	w.limit -= n
+	if w.limit == 0 {
+		return n, io.ErrClosedPipe
+	}
+	return n, nil
+}
+
+func TestWrapWriter(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	plaintext := make([]byte, 40000)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	want := make([]byte, len(plaintext))
+	New(key[:], iv[:], 20).XORKeyStream(want, plaintext)
+
+	var buf bytes.Buffer
+	c := New(key[:], iv[:], 20)
+	w := c.WrapWriter(&buf)
+	w.Write(plaintext[:3])
+	w.Write(plaintext[3:])
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("WrapWriter(), wrong ciphertext")
+	}
+
+	// A short write advances the keystream only as far as was written
+	for _, limit := range []int{0, 1, 100, 16*1024 + 5} {
+		c.Reset()
+		lw := &limitedWriter{limit: limit}
+		n, err := c.WrapWriter(lw).Write(plaintext)
+		if n != limit || err == nil {
+			t.Errorf("Write(), got %v, %v, want %v, error", n, err, limit)
+		}
+		if got := c.Tell(); got != uint64(limit) {
+			t.Errorf("Tell() after short write, got %v, want %v", got, limit)
+		}
+		if !bytes.Equal(lw.Bytes(), want[:limit]) {
+			t.Errorf("WrapWriter(), wrong ciphertext after short write")
+		}
+	}
+
+	// io.Copy goes through ReadFrom, with the same results
+	c.Reset()
+	buf.Reset()
+	n, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(plaintext)))
+	if n != int64(len(plaintext)) || err != nil {
+		t.Errorf("io.Copy(), got %v, %v, want %v, nil", n, err, len(plaintext))
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("ReadFrom(), wrong ciphertext")
+	}
+	c.Reset()
+	lw := &limitedWriter{limit: 100}
+	n, err = c.WrapWriter(lw).(io.ReaderFrom).ReadFrom(bytes.NewReader(plaintext))
+	if n != 100 || err == nil || c.Tell() != 100 {
+		t.Errorf("ReadFrom() short write, got %v, %v, at %v", n, err, c.Tell())
+	}
+	rerr := errors.New("read failed")
+	c.Reset()
+	buf.Reset()
+	r := io.MultiReader(bytes.NewReader(plaintext[:70]), iotest.ErrReader(rerr))
+	n, err = io.Copy(w, r)
+	if n != 70 || err != rerr || !bytes.Equal(buf.Bytes(), want[:70]) {
+		t.Errorf("io.Copy() read error, got %v, %v, want 70, %v", n, err, rerr)
+	}
+
+	if allocs := testing.AllocsPerRun(10, func() {
+		c.Reset()
+		w.Write(plaintext)
+		buf.Reset()
+	}); allocs != 0 {
+		t.Errorf("Write(), got %v allocations, want 0", allocs)
+	}
+}
+
+func BenchmarkWrapWriterCopy(b *testing.B) {
+	var key [32]byte
+	var iv [8]byte
+	src := make([]byte, 1<<20)
+	b.Run("ReadFrom", func(b *testing.B) {
+		c := New(key[:], iv[:], 20)
+		w := c.WrapWriter(io.Discard)
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			c.Reset()
+			io.Copy(w, struct{ io.Reader }{bytes.NewReader(src)})
+		}
+	})
+	b.Run("Write", func(b *testing.B) {
+		c := New(key[:], iv[:], 20)
+		w := struct{ io.Writer }{c.WrapWriter(io.Discard)}
+		b.SetBytes(int64(len(src)))
+		for i := 0; i < b.N; i++ {
+			c.Reset()
+			io.Copy(w, struct{ io.Reader }{bytes.NewReader(src)})
+		}
+	})
+}
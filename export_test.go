@@ -0,0 +1,18 @@
+package chacha
+
+// Test hooks into internal cipher state.
+
+// Sets the raw block counter, buffer position, and eof flag, without any
+// validation or block generation, so that tests can place the cipher in
+// any state, including ones unreachable through the API.
+func forceState(c *Cipher, counter uint64, nextByte int, eof bool) {
+	c.setCounter(counter)
+	c.nextByte = nextByte
+	c.eof = eof
+}
+
+// Reports the raw eof flag, which is set as soon as the final block is
+// generated, even while its bytes remain buffered.
+func eofFlag(c *Cipher) bool {
+	return c.eof
+}
@@ -0,0 +1,41 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+// GenerateKey returns a random key from crypto/rand.
+func GenerateKey() ([KeySize]byte, error) {
+	var key [KeySize]byte
+	_, err := io.ReadFull(rand.Reader, key[:])
+	return key, err
+}
+
+// GenerateNonce returns a random 8-byte nonce (IV) from crypto/rand. A
+// random nonce this short risks repeating after about 2^32 messages
+// under one key, so prefer counters, or GenerateNonceX with NewX.
+func GenerateNonce() ([NonceSize]byte, error) {
+	var nonce [NonceSize]byte
+	_, err := io.ReadFull(rand.Reader, nonce[:])
+	return nonce, err
+}
+
+// GenerateNonceIETF returns a random 12-byte nonce from crypto/rand, for
+// NewIETF and NewAEAD. Random nonces of this size are only safe for
+// about 2^32 messages under one key.
+func GenerateNonceIETF() ([NonceSizeIETF]byte, error) {
+	var nonce [NonceSizeIETF]byte
+	_, err := io.ReadFull(rand.Reader, nonce[:])
+	return nonce, err
+}
+
+// GenerateNonceX returns a random 24-byte nonce from crypto/rand, for NewX
+// and NewXAEAD. Nonces this long may safely be chosen at random.
+func GenerateNonceX() ([NonceSizeX]byte, error) {
+	var nonce [NonceSizeX]byte
+	_, err := io.ReadFull(rand.Reader, nonce[:])
+	return nonce, err
+}
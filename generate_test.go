@@ -0,0 +1,47 @@
+package chacha
+
+import (
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	k1, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, _ := GenerateKey()
+	if k1 == k2 || k1 == [KeySize]byte{} {
+		t.Errorf("GenerateKey(), got %v and %v", k1, k2)
+	}
+
+	n1, err := GenerateNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, _ := GenerateNonce()
+	if n1 == n2 {
+		t.Errorf("GenerateNonce(), got %v twice", n1)
+	}
+	if _, err := NewCipher(k1[:], n1[:], 20); err != nil {
+		t.Error(err)
+	}
+
+	i1, err := GenerateNonceIETF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i2, _ := GenerateNonceIETF(); i1 == i2 {
+		t.Errorf("GenerateNonceIETF(), got %v twice", i1)
+	}
+
+	x1, err := GenerateNonceX()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x2, _ := GenerateNonceX(); x1 == x2 {
+		t.Errorf("GenerateNonceX(), got %v twice", x1)
+	}
+	if _, err := NewX(k1[:], x1[:], 20); err != nil {
+		t.Error(err)
+	}
+}
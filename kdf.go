@@ -0,0 +1,55 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errOutLen = errors.New("chacha: invalid output length")
+
+// DeriveKey derives outLen bytes of subkey material from a 32-byte master
+// key and an arbitrary context, such that distinct contexts give
+// independent subkeys. The context acts as a salt: it is compressed into
+// a per-context ChaCha20 key, whose keystream is the output. The same
+// inputs always produce the same output, and a shorter output is a prefix
+// of a longer one.
+//
+// This is a key derivation function for keys that are already uniformly
+// random. It is not a password hash and does nothing to slow down
+// guessing, so never use it on passwords.
+func DeriveKey(masterKey, context []byte, outLen int) ([]byte, error) {
+	if len(masterKey) != KeySize {
+		return nil, ErrKeySize
+	}
+	if outLen < 0 {
+		return nil, errOutLen
+	}
+	key := deriveContextKey(masterKey, context)
+	out := make([]byte, outLen)
+	New(key[:], make([]byte, NonceSize), 20).KeyStream(out)
+	return out, nil
+}
+
+// Compresses a context into a key by chaining HChaCha20 over 16-byte
+// chunks of the context, each keyed by the previous result. Prefixing
+// the context length makes the encoding unambiguous.
+func deriveContextKey(masterKey, context []byte) [32]byte {
+	var key [32]byte
+	copy(key[:], masterKey)
+
+	var chunk [16]byte
+	binary.LittleEndian.PutUint64(chunk[:], uint64(len(context)))
+	n := copy(chunk[8:], context)
+	context = context[n:]
+	for {
+		key = hchacha(key[:], chunk[:], 20)
+		if len(context) == 0 {
+			return key
+		}
+		chunk = [16]byte{}
+		n = copy(chunk[:], context)
+		context = context[n:]
+	}
+}
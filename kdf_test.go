@@ -0,0 +1,58 @@
+package chacha
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	master := make([]byte, 32)
+	for i := range master {
+		master[i] = byte(i)
+	}
+
+	long, err := DeriveKey(master, []byte("example"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, _ := DeriveKey(master, []byte("example"), 32)
+	if !bytes.Equal(short, long[:32]) {
+		t.Errorf("DeriveKey(32), got %x, want %x", short, long[:32])
+	}
+
+	// Every context, including those differing only in trailing zeros or
+	// chunk boundaries, gives a different key
+	contexts := [][]byte{
+		nil,
+		{0},
+		[]byte("example"),
+		[]byte("example\x00"),
+		[]byte("exampl"),
+		bytes.Repeat([]byte{'x'}, 8),
+		bytes.Repeat([]byte{'x'}, 9),
+		bytes.Repeat([]byte{'x'}, 24),
+		bytes.Repeat([]byte{'x'}, 25),
+		append(bytes.Repeat([]byte{'x'}, 24), 0),
+	}
+	seen := make(map[string]bool)
+	for _, context := range contexts {
+		key, _ := DeriveKey(master, context, 32)
+		if seen[string(key)] {
+			t.Errorf("DeriveKey(%q), duplicate key %x", context, key)
+		}
+		seen[string(key)] = true
+	}
+
+	// A different master key gives a different subkey
+	master[0] ^= 1
+	if other, _ := DeriveKey(master, []byte("example"), 32); bytes.Equal(other, short) {
+		t.Errorf("DeriveKey(), same key for different master keys")
+	}
+
+	if _, err := DeriveKey(master[:16], nil, 32); err != ErrKeySize {
+		t.Errorf("DeriveKey(short key), got %v, want %v", err, ErrKeySize)
+	}
+	if _, err := DeriveKey(master, nil, -1); err == nil {
+		t.Errorf("DeriveKey(-1), got nil error")
+	}
+}
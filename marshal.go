@@ -0,0 +1,164 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+)
+
+// Serialized state layout, version 1:
+//
+//	[0]     version
+//	[1]     flags
+//	[2]     rounds
+//	[3]     nextByte
+//	[4:68]  input, 16 little endian words
+const (
+	stateVersion = 1
+	stateSize    = 4 + 16*4
+
+	flagEOF   = 1 << 0
+	flagIETF  = 1 << 1
+	flagWrap  = 1 << 2
+	flagSalsa = 1 << 3
+	flagUnbuf = 1 << 4
+	flagMask  = flagEOF | flagIETF | flagWrap | flagSalsa | flagUnbuf
+)
+
+var (
+	errStateSize    = errors.New("chacha: invalid state length")
+	errStateVersion = errors.New("chacha: unsupported state version")
+	errState        = errors.New("chacha: invalid state")
+)
+
+var _ encoding.BinaryMarshaler = (*Cipher)(nil)
+var _ encoding.BinaryUnmarshaler = (*Cipher)(nil)
+var _ gob.GobEncoder = (*Cipher)(nil)
+var _ gob.GobDecoder = (*Cipher)(nil)
+
+// MarshalBinary implements encoding.BinaryMarshaler. It captures the
+// complete cipher state, including key and nonce, so the result must be
+// protected like a key. It never returns an error.
+func (c *Cipher) MarshalBinary() ([]byte, error) {
+	var flags byte
+	if c.eof {
+		flags |= flagEOF
+	}
+	if c.ietf {
+		flags |= flagIETF
+	}
+	if c.wrap {
+		flags |= flagWrap
+	}
+	if c.salsa {
+		flags |= flagSalsa
+	}
+	if c.unbuf {
+		flags |= flagUnbuf
+	}
+
+	buf := make([]byte, stateSize)
+	buf[0] = stateVersion
+	buf[1] = flags
+	buf[2] = byte(c.rounds)
+	buf[3] = byte(c.nextByte)
+	for i, v := range c.input {
+		binary.LittleEndian.PutUint32(buf[4+i*4:], v)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, restoring a state
+// produced by MarshalBinary. The cipher resumes at the exact keystream
+// position it was marshaled at. Malformed input is rejected with an error,
+// leaving the cipher unchanged.
+func (c *Cipher) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return errStateSize
+	}
+	if data[0] != stateVersion {
+		return errStateVersion
+	}
+	if len(data) != stateSize {
+		return errStateSize
+	}
+
+	var t Cipher
+	flags := data[1]
+	rounds := int(data[2])
+	nextByte := int(data[3])
+	if flags&^flagMask != 0 || flags&flagIETF != 0 && flags&flagSalsa != 0 {
+		return errState
+	}
+	if !validRounds(rounds) {
+		return errState
+	}
+	if nextByte > len(t.output) {
+		return errState
+	}
+	if flags&flagUnbuf != 0 && nextByte != len(t.output) {
+		return errState
+	}
+
+	for i := range t.input {
+		t.input[i] = binary.LittleEndian.Uint32(data[4+i*4:])
+	}
+	t.rounds = rounds
+	t.ietf = flags&flagIETF != 0
+	t.wrap = flags&flagWrap != 0
+	t.salsa = flags&flagSalsa != 0
+	t.unbuf = flags&flagUnbuf != 0
+	t.restore(nextByte, flags&flagEOF != 0)
+
+	*c = t
+	return nil
+}
+
+// GobEncode implements gob.GobEncoder using the MarshalBinary format.
+func (c *Cipher) GobEncode() ([]byte, error) {
+	return c.MarshalBinary()
+}
+
+// GobDecode implements gob.GobDecoder using the MarshalBinary format.
+func (c *Cipher) GobDecode(data []byte) error {
+	return c.UnmarshalBinary(data)
+}
+
+// NewFromState returns a cipher built directly from raw state: the 16
+// input words (constants, key, counter, and IV, in that order), the
+// offset of the next byte within the current block, the number of rounds,
+// and whether the keystream is exhausted. The input words use the
+// original layout, with a 64-bit counter in words 12 and 13. nextByte must
+// be in [0, 64], where 64 means no keystream is buffered.
+func NewFromState(input [16]uint32, nextByte, rounds int, eof bool) (*Cipher, error) {
+	if !validRounds(rounds) {
+		return nil, ErrRounds
+	}
+	c := new(Cipher)
+	if nextByte < 0 || nextByte > len(c.output) {
+		return nil, errState
+	}
+	c.input = input
+	c.rounds = rounds
+	c.restore(nextByte, eof)
+	return c, nil
+}
+
+// Completes a restored state, regenerating the buffered block, which
+// precedes the counter, if any of it remains unread.
+func (c *Cipher) restore(nextByte int, eof bool) {
+	if nextByte < len(c.output) {
+		ctr := c.counter()
+		c.setCounter(ctr - 1)
+		c.eof = false
+		if _, err := c.nextBlocks(c.output[:], 1); err != nil {
+			nextByte = len(c.output) // zeroized
+		}
+		c.setCounter(ctr)
+	}
+	c.nextByte = nextByte
+	c.eof = eof
+}
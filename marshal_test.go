@@ -0,0 +1,140 @@
+package chacha
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	iv := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	nonce := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	ietf, _ := NewIETF(key, nonce, 12)
+
+	ciphers := []*Cipher{New(key, iv, 20), New(key, iv, 8), ietf}
+	for _, c := range ciphers {
+		for _, skip := range []int{0, 1, 63, 64, 65, 1000} {
+			c.Reset()
+			c.Read(make([]byte, skip))
+
+			state, err := c.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make([]byte, 200)
+			c.Read(want)
+
+			var d Cipher
+			if err := d.UnmarshalBinary(state); err != nil {
+				t.Fatal(err)
+			}
+			if d.Rounds() != c.Rounds() {
+				t.Errorf("Rounds(), got %v, want %v", d.Rounds(), c.Rounds())
+			}
+			got := make([]byte, 200)
+			d.Read(got)
+			if !bytes.Equal(got, want) {
+				t.Errorf("UnmarshalBinary(), skip %d, got %v, want %v",
+					skip, got, want)
+			}
+		}
+	}
+
+	// Exhausted state survives the round trip
+	c := New(key, iv, 20)
+	c.Seek(0xffffffffffffffff)
+	c.Read(make([]byte, 10))
+	state, _ := c.MarshalBinary()
+	var d Cipher
+	d.UnmarshalBinary(state)
+	if n, _ := d.Read(make([]byte, 100)); n != 54 {
+		t.Errorf("Read(), got %v, want %v", n, 54)
+	}
+
+	// Wrapping survives the round trip
+	c.SetWrapOnOverflow(true)
+	state, _ = c.MarshalBinary()
+	d.UnmarshalBinary(state)
+	if n, _ := d.Read(make([]byte, 100)); n != 100 {
+		t.Errorf("Read(), got %v, want %v", n, 100)
+	}
+
+	// Malformed input
+	bad := [][]byte{
+		nil,
+		state[:len(state)-1],
+		append([]byte{2}, state[1:]...),
+		append([]byte{1, 0x80}, state[2:]...),
+		append([]byte{1, 0, 7}, state[3:]...),
+		append([]byte{1, 0, 20, 65}, state[4:]...),
+	}
+	for _, b := range bad {
+		if err := d.UnmarshalBinary(b); err == nil {
+			t.Errorf("UnmarshalBinary(%v), got nil error", b)
+		}
+	}
+}
+
+func TestNewFromState(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	iv := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	c := New(key, iv, 12)
+	c.Read(make([]byte, 100))
+
+	state := c.State()
+	if state[4] != 0x03020100 || state[13] != 0 || state[12] != 2 || state[15] != 0x08070605 {
+		t.Errorf("State(), got %#x", state)
+	}
+	d, err := NewFromState(state, c.nextByte, c.rounds, c.eof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 200)
+	got := make([]byte, 200)
+	c.Read(want)
+	d.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("NewFromState(), got %v, want %v", got, want)
+	}
+
+	if _, err := NewFromState(c.input, 65, 20, false); err == nil {
+		t.Errorf("NewFromState(nextByte 65), got nil error")
+	}
+	if _, err := NewFromState(c.input, -1, 20, false); err == nil {
+		t.Errorf("NewFromState(nextByte -1), got nil error")
+	}
+	if _, err := NewFromState(c.input, 0, 7, false); err != ErrRounds {
+		t.Errorf("NewFromState(7 rounds), got %v, want %v", err, ErrRounds)
+	}
+}
+
+func TestGob(t *testing.T) {
+	key := make([]byte, 32)
+	iv := make([]byte, 8)
+	c := New(key, iv, 20)
+	c.Read(make([]byte, 77))
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		t.Fatal(err)
+	}
+	var d *Cipher
+	if err := gob.NewDecoder(&buf).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]byte, 100)
+	got := make([]byte, 100)
+	c.Read(want)
+	d.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("gob round trip, got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrNonceExhausted is returned by NonceSequence.Next once every nonce in
+// the sequence has been used.
+var ErrNonceExhausted = errors.New("chacha: nonce sequence exhausted")
+
+// NonceSequence produces distinct 12-byte nonces for NewAEAD, one per
+// message: a fixed 4-byte prefix followed by a 64-bit big endian counter
+// starting at zero. Drawing every nonce from one sequence guarantees that
+// none repeats under a key. The zero value is a sequence with an all-zero
+// prefix. A NonceSequence is not safe for concurrent use.
+type NonceSequence struct {
+	prefix  [4]byte
+	counter uint64
+	done    bool
+}
+
+// NewRandomNonceSequence returns a sequence with a random prefix from
+// crypto/rand, so that sequences started independently under the same
+// key are unlikely to overlap.
+func NewRandomNonceSequence() (*NonceSequence, error) {
+	s := new(NonceSequence)
+	if _, err := io.ReadFull(rand.Reader, s.prefix[:]); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Next returns the next nonce in the sequence, or ErrNonceExhausted rather
+// than wrapping around once the counter has been used up.
+func (s *NonceSequence) Next() ([]byte, error) {
+	if s.done {
+		return nil, ErrNonceExhausted
+	}
+	nonce := make([]byte, NonceSizeIETF)
+	copy(nonce, s.prefix[:])
+	binary.BigEndian.PutUint64(nonce[4:], s.counter)
+	s.counter++
+	s.done = s.counter == 0
+	return nonce, nil
+}
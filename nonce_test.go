@@ -0,0 +1,44 @@
+package chacha
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestNonceSequence(t *testing.T) {
+	s, err := NewRandomNonceSequence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := s.Next()
+	second, _ := s.Next()
+	if len(first) != NonceSizeIETF {
+		t.Fatalf("Next(), got %d bytes, want %d", len(first), NonceSizeIETF)
+	}
+	if !bytes.Equal(first[:4], second[:4]) {
+		t.Errorf("Next(), prefix changed from %x to %x", first[:4], second[:4])
+	}
+	if !bytes.Equal(first[4:], make([]byte, 8)) ||
+		!bytes.Equal(second[4:], []byte{0, 0, 0, 0, 0, 0, 0, 1}) {
+		t.Errorf("Next(), got counters %x, %x", first[4:], second[4:])
+	}
+
+	// The final counter is used once, then the sequence ends
+	s.counter = math.MaxUint64
+	last, err := s.Next()
+	if err != nil || !bytes.Equal(last[4:], bytes.Repeat([]byte{0xff}, 8)) {
+		t.Errorf("Next(), got %x, %v", last, err)
+	}
+	for i := 0; i < 2; i++ {
+		if n, err := s.Next(); n != nil || err != ErrNonceExhausted {
+			t.Errorf("Next() after end, got %x, %v, want nil, %v",
+				n, err, ErrNonceExhausted)
+		}
+	}
+
+	var zero NonceSequence
+	if n, _ := zero.Next(); !bytes.Equal(n, make([]byte, NonceSizeIETF)) {
+		t.Errorf("Next() on zero value, got %x", n)
+	}
+}
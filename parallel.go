@@ -0,0 +1,73 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Smallest share of a parallel XOR worth handing to a goroutine.
+const minParallelBlocks = 256 // 16 KiB
+
+// XORKeyStreamParallel is like XORKeyStream, but splits large inputs
+// across up to the given number of goroutines, each XORing a disjoint
+// region of the keystream starting on a block boundary. If workers is
+// less than 1, GOMAXPROCS is used. The output and the final stream
+// position are identical to XORKeyStream's. Small inputs, and inputs that
+// would exhaust the keystream, are processed serially.
+func (c *Cipher) XORKeyStreamParallel(dst, src []byte, workers int) {
+	if len(dst) < len(src) {
+		panic("chacha: output smaller than input")
+	}
+	dst = dst[:len(src)]
+	if inexactOverlap(dst, src) {
+		panic("chacha: invalid buffer overlap")
+	}
+	if c.unbuf && len(src)%len(c.output) != 0 {
+		panic(ErrUnaligned) // before any worker writes
+	}
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if !c.wrap && uint64(len(src)) > c.Remaining() {
+		workers = 1
+	}
+
+	// Finish the buffered block so that workers start on a boundary
+	head := len(c.output) - c.nextByte
+	if head > len(src) {
+		head = len(src)
+	}
+	blocks := (len(src) - head) / len(c.output)
+	if blocks/workers < minParallelBlocks {
+		workers = blocks / minParallelBlocks
+	}
+	if workers < 2 {
+		c.XORKeyStream(dst, src)
+		return
+	}
+	c.XORKeyStream(dst[:head], src[:head])
+	dst = dst[head:]
+	src = src[head:]
+
+	start := c.Counter()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		lo := blocks * i / workers
+		hi := blocks * (i + 1) / workers
+		w := c.Clone()
+		w.SeekBlock(start + uint64(lo))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lo, hi := lo*len(c.output), hi*len(c.output)
+			w.XORKeyStream(dst[lo:hi], src[lo:hi])
+		}()
+	}
+	wg.Wait()
+
+	n := blocks * len(c.output)
+	c.Skip(uint64(n))
+	c.XORKeyStream(dst[n:], src[n:])
+}
@@ -0,0 +1,88 @@
+package chacha
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestXORKeyStreamParallel(t *testing.T) {
+	var key [32]byte
+	var nonce [12]byte
+	ietf, _ := NewIETF(key[:], nonce[:], 20)
+	ciphers := []*Cipher{New(key[:], nonce[:8], 20), ietf}
+
+	src := make([]byte, 1<<20+37)
+	for i := range src {
+		src[i] = byte(i * 31)
+	}
+	for _, c := range ciphers {
+		for _, skip := range []int{0, 1, 64, 100} {
+			for _, workers := range []int{0, 1, 3, 8} {
+				for _, size := range []int{100, 64 << 10, len(src)} {
+					c.Reset()
+					c.Read(make([]byte, skip))
+					serial := c.Clone()
+					want := make([]byte, size)
+					serial.XORKeyStream(want, src[:size])
+
+					got := make([]byte, size)
+					c.XORKeyStreamParallel(got, src[:size], workers)
+					if !bytes.Equal(got, want) {
+						t.Errorf("XORKeyStreamParallel(%d, %d) after %d, wrong output",
+							size, workers, skip)
+					}
+					if *c != *serial {
+						t.Errorf("XORKeyStreamParallel(%d, %d) after %d, wrong state",
+							size, workers, skip)
+					}
+				}
+			}
+		}
+	}
+
+	// In place, and running exactly to the end of the keystream
+	c := ciphers[1]
+	c.Seek(1<<32 - 1<<10)
+	serial := c.Clone()
+	want := append([]byte(nil), src[:1<<16]...)
+	serial.XORKeyStream(want, want)
+	got := append([]byte(nil), src[:1<<16]...)
+	c.XORKeyStreamParallel(got, got, 4)
+	if !bytes.Equal(got, want) || *c != *serial {
+		t.Errorf("XORKeyStreamParallel() to end of keystream, wrong result")
+	}
+
+	// Unbuffered ciphers reject unaligned lengths before writing anything
+	u, _ := NewUnbuffered(key[:], nonce[:8], 20)
+	dst := make([]byte, 1<<20+10)
+	func() {
+		defer func() {
+			if r := recover(); r != ErrUnaligned {
+				t.Errorf("XORKeyStreamParallel(unaligned), got panic %v, want %v",
+					r, ErrUnaligned)
+			}
+		}()
+		u.XORKeyStreamParallel(dst, src[:len(dst)], 4)
+	}()
+	if u.Tell() != 0 || !bytes.Equal(dst, make([]byte, len(dst))) {
+		t.Errorf("XORKeyStreamParallel(unaligned) wrote or advanced before panicking")
+	}
+	want = make([]byte, 1<<20)
+	u.Clone().XORKeyStream(want, src[:len(want)])
+	got = make([]byte, len(want))
+	u.XORKeyStreamParallel(got, src[:len(got)], 4)
+	if !bytes.Equal(got, want) {
+		t.Errorf("XORKeyStreamParallel(unbuffered), wrong output")
+	}
+}
+
+func BenchmarkXORKeyStreamParallel(b *testing.B) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	buf := make([]byte, 4<<20)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		c.XORKeyStreamParallel(buf, buf, 0)
+	}
+}
@@ -0,0 +1,127 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Incremental Poly1305 one-time authenticator (RFC 8439, section 2.5).
+// The accumulator h is kept in three 64-bit limbs and only partially
+// reduced modulo 2^130 - 5 until the final sum.
+type poly1305 struct {
+	h   [3]uint64
+	r   [2]uint64
+	s   [2]uint64
+	buf [16]byte
+	n   int // bytes pending in buf
+}
+
+func newPoly1305(key *[32]byte) *poly1305 {
+	p := new(poly1305)
+	p.r[0] = binary.LittleEndian.Uint64(key[0:]) & 0x0ffffffc0fffffff
+	p.r[1] = binary.LittleEndian.Uint64(key[8:]) & 0x0ffffffc0ffffffc
+	p.s[0] = binary.LittleEndian.Uint64(key[16:])
+	p.s[1] = binary.LittleEndian.Uint64(key[24:])
+	return p
+}
+
+// Absorbs msg into the authenticator.
+func (p *poly1305) update(msg []byte) {
+	if p.n > 0 {
+		n := copy(p.buf[p.n:], msg)
+		p.n += n
+		msg = msg[n:]
+		if p.n < len(p.buf) {
+			return
+		}
+		p.blocks(p.buf[:], 1)
+		p.n = 0
+	}
+	if full := len(msg) &^ 15; full > 0 {
+		p.blocks(msg[:full], 1)
+		msg = msg[full:]
+	}
+	p.n = copy(p.buf[:], msg)
+}
+
+// Absorbs zeros up to the next 16-byte boundary of the input so far.
+func (p *poly1305) pad() {
+	if p.n > 0 {
+		var zero [16]byte
+		p.update(zero[p.n:])
+	}
+}
+
+// Processes whole 16-byte blocks, each with 2^128 (hibit) appended.
+func (p *poly1305) blocks(msg []byte, hibit uint64) {
+	h0, h1, h2 := p.h[0], p.h[1], p.h[2]
+	r0, r1 := p.r[0], p.r[1]
+	for ; len(msg) >= 16; msg = msg[16:] {
+		var c uint64
+		h0, c = bits.Add64(h0, binary.LittleEndian.Uint64(msg[0:]), 0)
+		h1, c = bits.Add64(h1, binary.LittleEndian.Uint64(msg[8:]), c)
+		h2 += c + hibit
+
+		// h *= r, as a 4-limb product t
+		h0r0hi, h0r0lo := bits.Mul64(h0, r0)
+		h1r0hi, h1r0lo := bits.Mul64(h1, r0)
+		h0r1hi, h0r1lo := bits.Mul64(h0, r1)
+		h1r1hi, h1r1lo := bits.Mul64(h1, r1)
+		h2r0 := h2 * r0 // h2 is small, and r is clamped, so no overflow
+		h2r1 := h2 * r1
+
+		t0 := h0r0lo
+		t1, c := bits.Add64(h1r0lo, h0r1lo, 0)
+		t2, c2 := bits.Add64(h1r0hi, h0r1hi, c)
+		t3 := c2
+		t1, c = bits.Add64(t1, h0r0hi, 0)
+		t2, c = bits.Add64(t2, h1r1lo, c)
+		t3 += c
+		t2, c = bits.Add64(t2, h2r0, 0)
+		t3 += c
+		t3 += h1r1hi + h2r1
+
+		// Reduce: 2^130 = 5 (mod p), so fold t>>130 back in times 5,
+		// computed as (t & ^3)>>128 * 4 + (t & ^3)>>130.
+		h0, h1, h2 = t0, t1, t2&3
+		cc0, cc1 := t2&^3, t3
+		h0, c = bits.Add64(h0, cc0, 0)
+		h1, c = bits.Add64(h1, cc1, c)
+		h2 += c
+		cc0 = cc0>>2 | cc1<<62
+		cc1 >>= 2
+		h0, c = bits.Add64(h0, cc0, 0)
+		h1, c = bits.Add64(h1, cc1, c)
+		h2 += c
+	}
+	p.h[0], p.h[1], p.h[2] = h0, h1, h2
+}
+
+// Writes the authenticator tag for everything absorbed so far.
+func (p *poly1305) sum(out *[16]byte) {
+	q := *p
+	if q.n > 0 {
+		q.buf[q.n] = 1
+		for i := q.n + 1; i < len(q.buf); i++ {
+			q.buf[i] = 0
+		}
+		q.blocks(q.buf[:], 0)
+	}
+
+	// Fully reduce h modulo 2^130 - 5, in constant time
+	h0, h1, h2 := q.h[0], q.h[1], q.h[2]
+	t0, b := bits.Sub64(h0, 0xfffffffffffffffb, 0)
+	t1, b := bits.Sub64(h1, 0xffffffffffffffff, b)
+	_, b = bits.Sub64(h2, 3, b)
+	mask := b - 1 // all ones if h >= p
+	h0 = h0&^mask | t0&mask
+	h1 = h1&^mask | t1&mask
+
+	var c uint64
+	h0, c = bits.Add64(h0, q.s[0], 0)
+	h1, _ = bits.Add64(h1, q.s[1], c)
+	binary.LittleEndian.PutUint64(out[0:], h0)
+	binary.LittleEndian.PutUint64(out[8:], h1)
+}
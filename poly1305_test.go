@@ -0,0 +1,71 @@
+package chacha
+
+import (
+	"testing"
+)
+
+func TestPoly1305(t *testing.T) {
+	ones := [16]byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	}
+	tests := []struct {
+		key [32]byte
+		msg []byte
+		tag [16]byte
+	}{
+		{
+			// RFC 8439, section 2.5.2
+			key: [32]byte{
+				0x85, 0xd6, 0xbe, 0x78, 0x57, 0x55, 0x6d, 0x33,
+				0x7f, 0x44, 0x52, 0xfe, 0x42, 0xd5, 0x06, 0xa8,
+				0x01, 0x03, 0x80, 0x8a, 0xfb, 0x0d, 0xb2, 0xfd,
+				0x4a, 0xbf, 0xf6, 0xaf, 0x41, 0x49, 0xf5, 0x1b,
+			},
+			msg: []byte("Cryptographic Forum Research Group"),
+			tag: [16]byte{
+				0xa8, 0x06, 0x1d, 0xc1, 0x30, 0x51, 0x36, 0xc6,
+				0xc2, 0x2b, 0x8b, 0xaf, 0x0c, 0x01, 0x27, 0xa9,
+			},
+		},
+		{
+			// RFC 8439, appendix A.3, #5: h reaches p
+			key: [32]byte{0x02},
+			msg: ones[:],
+			tag: [16]byte{0x03},
+		},
+		{
+			// RFC 8439, appendix A.3, #6: h + s overflows
+			key: [32]byte{
+				0x02, 0, 0, 0, 0, 0, 0, 0,
+				0, 0, 0, 0, 0, 0, 0, 0,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			},
+			msg: []byte{0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			tag: [16]byte{0x03},
+		},
+	}
+
+	for _, test := range tests {
+		// Feed the message in uneven pieces
+		for _, step := range []int{1, 5, 16, 100} {
+			key := test.key
+			p := newPoly1305(&key)
+			for m := test.msg; len(m) > 0; {
+				n := step
+				if n > len(m) {
+					n = len(m)
+				}
+				p.update(m[:n])
+				m = m[n:]
+			}
+			var got [16]byte
+			p.sum(&got)
+			if got != test.tag {
+				t.Errorf("poly1305(%x), step %d, got %x, want %x",
+					test.msg, step, got, test.tag)
+			}
+		}
+	}
+}
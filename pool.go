@@ -0,0 +1,32 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"sync"
+)
+
+var cipherPool = sync.Pool{
+	New: func() any { return new(Cipher) },
+}
+
+// AcquireCipher is like New, but takes the cipher from a pool, avoiding an
+// allocation where possible. It panics with any error NewCipher would
+// return, including for keys and IVs that are not exactly sized.
+// Return the cipher with ReleaseCipher when finished.
+func AcquireCipher(key, iv []byte, rounds int) *Cipher {
+	c := cipherPool.Get().(*Cipher)
+	if err := c.Rekey(key, iv, rounds); err != nil {
+		cipherPool.Put(c)
+		panic(err)
+	}
+	return c
+}
+
+// ReleaseCipher wipes the key material from c, as Zeroize does, and
+// returns it to the pool used by AcquireCipher. The cipher must not be
+// used afterwards.
+func ReleaseCipher(c *Cipher) {
+	c.Zeroize()
+	cipherPool.Put(c)
+}
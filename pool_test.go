@@ -0,0 +1,40 @@
+package chacha
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAcquireCipher(t *testing.T) {
+	key := make([]byte, 32)
+	iv := make([]byte, 8)
+	key[0] = 1
+	want := make([]byte, 100)
+	New(key, iv, 12).Read(want)
+
+	c := AcquireCipher(key, iv, 12)
+	got := make([]byte, 100)
+	c.Read(got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("AcquireCipher(), got %v, want %v", got, want)
+	}
+	ReleaseCipher(c)
+	if c.input != [16]uint32{} || c.output != [64]byte{} {
+		t.Errorf("ReleaseCipher() did not wipe the cipher")
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != ErrRounds {
+				t.Errorf("AcquireCipher(7 rounds), got panic %v, want %v", r, ErrRounds)
+			}
+		}()
+		AcquireCipher(key, iv, 7)
+	}()
+
+	if allocs := testing.AllocsPerRun(100, func() {
+		ReleaseCipher(AcquireCipher(key, iv, 20))
+	}); allocs != 0 {
+		t.Errorf("AcquireCipher(), got %v allocations, want 0", allocs)
+	}
+}
@@ -0,0 +1,165 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"io"
+)
+
+var errPrefetcherClosed = errors.New("chacha: use of closed Prefetcher")
+
+// Prefetcher serves a cipher's keystream while a background goroutine
+// computes the following block, so that crossing a block boundary only
+// swaps buffers rather than running the core. This smooths out latency
+// for consumers taking a few bytes at a time. Handing blocks between
+// goroutines costs more than computing them, so it is slower for bulk
+// use. A Prefetcher is not safe for concurrent use.
+type Prefetcher struct {
+	c        *Cipher
+	cur      *[BlockSize]byte
+	pos      int // next unread byte of cur
+	consumed uint64
+	full     chan *[BlockSize]byte
+	free     chan *[BlockSize]byte
+	done     chan struct{}
+	stopped  chan struct{}
+	closed   bool
+}
+
+var _ cipher.Stream = (*Prefetcher)(nil)
+var _ io.ReadCloser = (*Prefetcher)(nil)
+
+// NewPrefetcher returns a Prefetcher continuing from c's current position
+// and starts its goroutine. The caller must not use c until Close, which
+// stops the goroutine and advances c past everything the Prefetcher
+// produced. It panics with ErrUnaligned if c is from NewUnbuffered.
+func NewPrefetcher(c *Cipher) *Prefetcher {
+	c.mustBuffer()
+	p := &Prefetcher{
+		c:       c,
+		cur:     new([BlockSize]byte),
+		pos:     c.nextByte,
+		full:    make(chan *[BlockSize]byte, 1),
+		free:    make(chan *[BlockSize]byte, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	*p.cur = c.output
+	p.free <- new([BlockSize]byte)
+
+	gen := c.Clone()
+	gen.nextByte = len(gen.output)
+	go p.generate(gen)
+	return p
+}
+
+// Fills free buffers with successive blocks until the keystream is
+// exhausted or the Prefetcher is closed.
+func (p *Prefetcher) generate(gen *Cipher) {
+	defer close(p.stopped)
+	defer gen.Zeroize()
+	for {
+		var buf *[BlockSize]byte
+		select {
+		case buf = <-p.free:
+		case <-p.done:
+			return
+		}
+		if _, err := gen.nextBlocks(buf[:], 1); err != nil {
+			close(p.full)
+			return
+		}
+		select {
+		case p.full <- buf:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Swaps in the prefetched block, reporting false at the end of the
+// keystream.
+func (p *Prefetcher) advance() bool {
+	buf, ok := <-p.full
+	if !ok {
+		return false
+	}
+	p.free <- p.cur
+	p.cur = buf
+	p.pos = 0
+	return true
+}
+
+// Read is like Cipher.Read. After Close it returns an error.
+func (p *Prefetcher) Read(b []byte) (int, error) {
+	if p.closed {
+		return 0, errPrefetcherClosed
+	}
+	var n int
+	for n < len(b) {
+		if p.pos == len(p.cur) && !p.advance() {
+			return n, io.EOF
+		}
+		m := copy(b[n:], p.cur[p.pos:])
+		p.pos += m
+		p.consumed += uint64(m)
+		n += m
+	}
+	return n, nil
+}
+
+// XORKeyStream is like Cipher.XORKeyStream, including its panics. After
+// Close it panics.
+func (p *Prefetcher) XORKeyStream(dst, src []byte) {
+	if p.closed {
+		panic(errPrefetcherClosed)
+	}
+	if len(dst) < len(src) {
+		panic("chacha: output smaller than input")
+	}
+	dst = dst[:len(src)]
+	if inexactOverlap(dst, src) {
+		panic("chacha: invalid buffer overlap")
+	}
+	if remaining := p.c.Remaining() - p.consumed; uint64(len(src)) > remaining {
+		if remaining == 0 {
+			panic(ErrAlreadyExhausted)
+		}
+		panic(ErrExhausted)
+	}
+	for len(src) > 0 {
+		if p.pos == len(p.cur) && !p.advance() {
+			panic(ErrExhausted)
+		}
+		m := subtle.XORBytes(dst, src, p.cur[p.pos:])
+		p.pos += m
+		p.consumed += uint64(m)
+		dst = dst[m:]
+		src = src[m:]
+	}
+}
+
+// Close stops the background goroutine, waiting for it to exit, and
+// advances the underlying cipher to the position the Prefetcher reached,
+// so the cipher may be used again. Closing more than once is harmless.
+func (p *Prefetcher) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	<-p.stopped
+	*p.cur = [BlockSize]byte{}
+	select {
+	case buf := <-p.full:
+		if buf != nil {
+			*buf = [BlockSize]byte{}
+		}
+	default:
+	}
+	p.c.Skip(p.consumed)
+	return nil
+}
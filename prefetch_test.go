@@ -0,0 +1,64 @@
+package chacha
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestPrefetcher(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	want := make([]byte, 1000)
+	New(key[:], iv[:], 20).Read(want)
+
+	c := New(key[:], iv[:], 20)
+	c.Read(make([]byte, 10))
+	p := NewPrefetcher(c)
+	got := make([]byte, len(want))
+	for i := 10; i < 500; i += 7 {
+		end := i + 7
+		if end > 500 {
+			end = 500
+		}
+		if _, err := p.Read(got[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.XORKeyStream(got[500:], got[500:])
+	if !bytes.Equal(got[10:], want[10:]) {
+		t.Errorf("Prefetcher, got %x, want %x", got[10:], want[10:])
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Tell() != 1000 {
+		t.Errorf("Tell() after Close(), got %v, want 1000", c.Tell())
+	}
+	if _, err := p.Read(got); err == nil {
+		t.Errorf("Read() after Close(), got nil error")
+	}
+	p.Close()
+
+	// Exhaustion matches Cipher
+	c.Seek(math.MaxUint64)
+	c.Read(make([]byte, 4))
+	p = NewPrefetcher(c)
+	if n, err := p.Read(make([]byte, 100)); n != 60 || err != io.EOF {
+		t.Errorf("Read() at end, got %v, %v, want 60, %v", n, err, io.EOF)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrAlreadyExhausted {
+				t.Errorf("XORKeyStream() at end, got panic %v, want %v", r, ErrAlreadyExhausted)
+			}
+		}()
+		p.XORKeyStream(got[:1], got[:1])
+	}()
+	p.Close()
+	if !c.Exhausted() {
+		t.Errorf("Exhausted() after Close(), got false")
+	}
+}
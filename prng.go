@@ -0,0 +1,58 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import "io"
+
+// PRNG is a deterministic cryptographically secure pseudorandom number
+// generator built on ChaCha20. Unlike a raw Cipher, it never runs out:
+// when its keystream would be exhausted, it takes the first 32 bytes of
+// the final block as a new key, never outputting them, and continues from
+// block 0 under that key. Since that happens only every 2^70 bytes, it
+// provides no forward secrecy in practice: anyone who learns the state can
+// reproduce all earlier output. Output depends only on the seed, not on
+// how it is split across calls to Read. A PRNG is not safe for concurrent
+// use.
+type PRNG struct {
+	c *Cipher
+}
+
+var _ io.Reader = (*PRNG)(nil)
+
+// NewPRNG returns a PRNG seeded from seed, which may be any length but
+// should hold at least 256 bits of entropy. The same seed always produces
+// the same output.
+func NewPRNG(seed []byte) *PRNG {
+	var zero [KeySize]byte
+	key := deriveContextKey(zero[:], seed)
+	return &PRNG{New(key[:], zero[:NonceSize], 20)}
+}
+
+// Read fills p with pseudorandom bytes. It always returns len(p) and a
+// nil error.
+func (r *PRNG) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		avail := r.c.Remaining() - BlockSize // keep back the final block
+		if avail == 0 {
+			r.rekey()
+			continue
+		}
+		end := len(p)
+		if uint64(end-n) > avail {
+			end = n + int(avail)
+		}
+		r.c.KeyStream(p[n:end])
+		n = end
+	}
+	return n, nil
+}
+
+// Re-keys from the first half of the final block and rewinds.
+func (r *PRNG) rekey() {
+	var block [BlockSize]byte
+	r.c.KeyStream(block[:])
+	var iv [NonceSize]byte
+	r.c.Rekey(block[:KeySize], iv[:], 20)
+	block = [BlockSize]byte{}
+}
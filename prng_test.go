@@ -0,0 +1,42 @@
+package chacha
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestPRNG(t *testing.T) {
+	seed := []byte("example seed with plenty of entropy")
+	a := make([]byte, 1000)
+	b := make([]byte, 1000)
+	NewPRNG(seed).Read(a)
+	r := NewPRNG(seed)
+	r.Read(b[:3])
+	r.Read(b[3:])
+	if !bytes.Equal(a, b) {
+		t.Errorf("PRNG not deterministic")
+	}
+	NewPRNG([]byte("another seed")).Read(b)
+	if bytes.Equal(a, b) {
+		t.Errorf("PRNG same output for different seeds")
+	}
+
+	// At the end of the keystream, the final block becomes the next key
+	r = NewPRNG(seed)
+	r.c.Seek(math.MaxUint64 - 1)
+	ref := r.c.Clone()
+	want := make([]byte, 64+100)
+	ref.Read(want[:64])
+	var last [64]byte
+	ref.Read(last[:])
+	New(last[:32], make([]byte, 8), 20).Read(want[64:])
+
+	got := make([]byte, len(want))
+	if n, err := r.Read(got); n != len(got) || err != nil {
+		t.Errorf("Read(), got %v, %v", n, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Read() across rekey, got %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+)
+
+// Source64 returns a math/rand.Source64 drawing from the cipher's
+// keystream, 8 bytes at a time, for deterministic, seekable randomness:
+//
+//	r := rand.New(c.Source64())
+//
+// The source shares the cipher's state, so Seek, Reset, and so on affect
+// it too. Calling Seed re-keys the cipher from the seed, with a zero IV.
+// The source panics if the keystream is exhausted.
+func (c *Cipher) Source64() rand.Source64 {
+	return &source{c}
+}
+
+type source struct {
+	c *Cipher
+}
+
+func (s *source) Uint64() uint64 {
+	c := s.c
+	if c.nextByte <= len(c.output)-8 {
+		r := binary.LittleEndian.Uint64(c.output[c.nextByte:])
+		c.nextByte += 8
+		return r
+	}
+	var buf [8]byte
+	c.KeyStream(buf[:])
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+func (s *source) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Seed re-keys the cipher with the seed as a little endian key, keeping
+// the number of rounds.
+func (s *source) Seed(seed int64) {
+	var key [32]byte
+	var iv [8]byte
+	binary.LittleEndian.PutUint64(key[:], uint64(seed))
+	s.c.Rekey(key[:], iv[:], s.c.rounds)
+}
+
+// InfiniteReader returns a reader of the cipher's keystream that never
+// runs out: when the keystream is exhausted, it increments the nonce, as
+// a little endian integer, and continues from block 0 of the new
+// keystream. Reads never fail, so io.ReadFull of any size succeeds. The
+// reader shares the cipher's state.
+//
+// The output past the end is exactly the keystream of the next nonce, so
+// that nonce, and those after it, must not be used with the same key for
+// anything else. With SetWrapOnOverflow enabled, the counter wraps
+// instead and the nonce never advances.
+func (c *Cipher) InfiniteReader() io.Reader {
+	return infiniteReader{c}
+}
+
+type infiniteReader struct {
+	c *Cipher
+}
+
+func (r infiniteReader) Read(p []byte) (int, error) {
+	var total int
+	for {
+		n, err := r.c.Read(p[total:])
+		total += n
+		if err == nil {
+			return total, nil
+		}
+		r.c.nextNonce()
+	}
+}
+
+// Increments the nonce, carrying across all of its words, and rewinds to
+// the start of the new keystream.
+func (c *Cipher) nextNonce() {
+	first := 14
+	if c.ietf {
+		first = 13
+	}
+	for i := first; i < len(c.input); i++ {
+		c.input[i]++
+		if c.input[i] != 0 {
+			break
+		}
+	}
+	c.SetCounter(0)
+}
@@ -0,0 +1,85 @@
+package chacha
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestSource64(t *testing.T) {
+	var key [32]byte
+	var iv [8]byte
+	c := New(key[:], iv[:], 20)
+	want := make([]byte, 800)
+	c.Read(want)
+
+	// Consumes the keystream 8 bytes at a time, even when unaligned
+	c.SeekByte(4)
+	src := c.Source64()
+	for i := 4; i+8 <= len(want); i += 8 {
+		if got := src.Uint64(); got != binary.LittleEndian.Uint64(want[i:]) {
+			t.Fatalf("Uint64() at %d, got %#x, want %#x",
+				i, got, binary.LittleEndian.Uint64(want[i:]))
+		}
+	}
+
+	// Seeding is deterministic
+	src.Seed(42)
+	r := rand.New(src)
+	var a [16]int
+	for i := range a {
+		a[i] = r.Intn(1000)
+	}
+	r.Seed(42)
+	for i := range a {
+		if got := r.Intn(1000); got != a[i] {
+			t.Errorf("Intn() after Seed(), got %v, want %v", got, a[i])
+		}
+	}
+	r.Seed(43)
+	same := true
+	for i := range a {
+		same = same && r.Intn(1000) == a[i]
+	}
+	if same {
+		t.Errorf("Seed(43) reproduced Seed(42)")
+	}
+}
+
+func TestInfiniteReader(t *testing.T) {
+	var key [32]byte
+	nonce := []byte{0xff, 0xff, 0xff, 0xff, 1, 0, 0, 0, 7, 0, 0, 0}
+	next := []byte{0, 0, 0, 0, 2, 0, 0, 0, 7, 0, 0, 0}
+
+	ietf, _ := NewIETF(key[:], nonce, 20)
+	ietf.Seek(0xffffffff)
+	want := make([]byte, 64+100)
+	ietf.Read(want[:64])
+	ietfNext, _ := NewIETF(key[:], next, 20)
+	ietfNext.Read(want[64:])
+
+	c, _ := NewIETF(key[:], nonce, 20)
+	c.Seek(0xffffffff)
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(c.InfiniteReader(), got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("InfiniteReader(IETF), got %v, want %v", got, want)
+	}
+
+	// The original layout carries across both IV words
+	c = New(key[:], nonce[:8], 20)
+	c.Seek(0xffffffffffffffff)
+	c.Read(want[:64])
+	New(key[:], next[:8], 20).Read(want[64:])
+	c.Seek(0xffffffffffffffff)
+	if _, err := io.ReadFull(c.InfiniteReader(), got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("InfiniteReader(), got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	// ErrRecordTooLarge is returned when a record's length exceeds the
+	// limit, or is too large to encode.
+	ErrRecordTooLarge = errors.New("chacha: record too large")
+
+	// ErrRecordTooShort is returned when a record's length is too short
+	// to hold an authentication tag.
+	ErrRecordTooShort = errors.New("chacha: record too short")
+)
+
+// WriteRecord seals plaintext with aead and writes it to w as a single
+// self-describing record:
+//
+//	[4]  big endian length of the sealed message
+//	[N]  nonce, aead.NonceSize() bytes
+//	[L]  sealed message, ciphertext and tag
+//
+// The additional data is authenticated but not written. The nonce must be
+// exactly aead.NonceSize() bytes, and unique for the key as usual.
+func WriteRecord(w io.Writer, aead cipher.AEAD, nonce, plaintext, aad []byte) error {
+	if len(nonce) != aead.NonceSize() {
+		return ErrNonceSize
+	}
+	sealed := uint64(len(plaintext)) + uint64(aead.Overhead())
+	if sealed > math.MaxUint32 {
+		return ErrRecordTooLarge
+	}
+
+	header := 4 + len(nonce)
+	buf := make([]byte, header, header+int(sealed))
+	binary.BigEndian.PutUint32(buf, uint32(sealed))
+	copy(buf[4:], nonce)
+	buf = aead.Seal(buf, nonce, plaintext, aad)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadRecord reads a record written by WriteRecord and returns its
+// verified plaintext. Records with more than maxLen bytes of plaintext
+// are rejected with ErrRecordTooLarge before anything is allocated for
+// them. It returns io.EOF if r is at EOF before the record begins, and
+// io.ErrUnexpectedEOF if the record is cut short. Authentication failure
+// is reported with the error from aead.Open.
+func ReadRecord(r io.Reader, aead cipher.AEAD, aad []byte, maxLen int) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	sealed := uint64(binary.BigEndian.Uint32(length[:]))
+	overhead := uint64(aead.Overhead())
+	if sealed < overhead {
+		return nil, ErrRecordTooShort
+	}
+	if maxLen < 0 || sealed-overhead > uint64(maxLen) {
+		return nil, ErrRecordTooLarge
+	}
+
+	buf := make([]byte, aead.NonceSize()+int(sealed))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	nonce, ciphertext := buf[:aead.NonceSize()], buf[aead.NonceSize():]
+	return aead.Open(ciphertext[:0], nonce, ciphertext, aad)
+}
@@ -0,0 +1,63 @@
+package chacha
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"io"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	key := make([]byte, 32)
+	a, _ := NewAEAD(key, 20)
+	x, _ := NewXAEAD(key)
+	aad := []byte("header")
+
+	for _, aead := range []cipher.AEAD{a, x} {
+		var buf bytes.Buffer
+		messages := [][]byte{nil, []byte("hello"), make([]byte, 1000)}
+		for i, m := range messages {
+			nonce := make([]byte, aead.NonceSize())
+			nonce[0] = byte(i)
+			if err := WriteRecord(&buf, aead, nonce, m, aad); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stream := append([]byte(nil), buf.Bytes()...)
+
+		for _, m := range messages {
+			got, err := ReadRecord(&buf, aead, aad, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, m) {
+				t.Errorf("ReadRecord(), got %q, want %q", got, m)
+			}
+		}
+		if _, err := ReadRecord(&buf, aead, aad, 1000); err != io.EOF {
+			t.Errorf("ReadRecord() at end, got %v, want %v", err, io.EOF)
+		}
+
+		// Limits, truncation, and tampering
+		first := 4 + aead.NonceSize() + aead.Overhead()
+		if _, err := ReadRecord(bytes.NewReader(stream[first:]), aead, aad, 4); err != ErrRecordTooLarge {
+			t.Errorf("ReadRecord(over limit), got %v, want %v", err, ErrRecordTooLarge)
+		}
+		if _, err := ReadRecord(bytes.NewReader(stream[:first-1]), aead, aad, 10); err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadRecord(truncated), got %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+		if _, err := ReadRecord(bytes.NewReader(stream[:2]), aead, aad, 10); err != io.ErrUnexpectedEOF {
+			t.Errorf("ReadRecord(truncated length), got %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+		short := []byte{0, 0, 0, 15}
+		if _, err := ReadRecord(bytes.NewReader(short), aead, aad, 10); err != ErrRecordTooShort {
+			t.Errorf("ReadRecord(short), got %v, want %v", err, ErrRecordTooShort)
+		}
+		if _, err := ReadRecord(bytes.NewReader(stream), aead, []byte("other"), 10); err == nil {
+			t.Errorf("ReadRecord(wrong aad), got nil error")
+		}
+		if err := WriteRecord(&buf, aead, make([]byte, 5), nil, nil); err != ErrNonceSize {
+			t.Errorf("WriteRecord(bad nonce), got %v, want %v", err, ErrNonceSize)
+		}
+	}
+}
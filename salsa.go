@@ -0,0 +1,75 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// NewSalsa20 returns a Salsa20 cipher, ChaCha's predecessor, for
+// decrypting data produced by it. The key, IV, and rounds are validated
+// as in NewCipher, and the result supports the same methods, with a
+// 64-bit block counter. Prefer ChaCha for new designs.
+func NewSalsa20(key, iv []byte, rounds int) (*Cipher, error) {
+	c, err := NewCipher(key, iv, rounds)
+	if err != nil {
+		return nil, err
+	}
+	c.salsa = true
+	return c, nil
+}
+
+// Computes the Salsa20 keystream block for the given state into out. The
+// state is kept in ChaCha's word order, so that keys, nonces, and the
+// counter live where the rest of Cipher expects them, and is rearranged
+// into Salsa20's order here.
+func salsaBlock(out *[BlockSize]byte, in *[16]uint32, rounds int) {
+	var s [16]uint32
+	s[0], s[5], s[10], s[15] = in[0], in[1], in[2], in[3]
+	s[1], s[2], s[3], s[4] = in[4], in[5], in[6], in[7]
+	s[11], s[12], s[13], s[14] = in[8], in[9], in[10], in[11]
+	s[8], s[9] = in[12], in[13]
+	s[6], s[7] = in[14], in[15]
+
+	x0, x1, x2, x3 := s[0], s[1], s[2], s[3]
+	x4, x5, x6, x7 := s[4], s[5], s[6], s[7]
+	x8, x9, x10, x11 := s[8], s[9], s[10], s[11]
+	x12, x13, x14, x15 := s[12], s[13], s[14], s[15]
+
+	for i := rounds; i > 0; i -= 2 {
+		x0, x4, x8, x12 = salsaQuarterRound(x0, x4, x8, x12)
+		x5, x9, x13, x1 = salsaQuarterRound(x5, x9, x13, x1)
+		x10, x14, x2, x6 = salsaQuarterRound(x10, x14, x2, x6)
+		x15, x3, x7, x11 = salsaQuarterRound(x15, x3, x7, x11)
+		x0, x1, x2, x3 = salsaQuarterRound(x0, x1, x2, x3)
+		x5, x6, x7, x4 = salsaQuarterRound(x5, x6, x7, x4)
+		x10, x11, x8, x9 = salsaQuarterRound(x10, x11, x8, x9)
+		x15, x12, x13, x14 = salsaQuarterRound(x15, x12, x13, x14)
+	}
+
+	binary.LittleEndian.PutUint32(out[0:], x0+s[0])
+	binary.LittleEndian.PutUint32(out[4:], x1+s[1])
+	binary.LittleEndian.PutUint32(out[8:], x2+s[2])
+	binary.LittleEndian.PutUint32(out[12:], x3+s[3])
+	binary.LittleEndian.PutUint32(out[16:], x4+s[4])
+	binary.LittleEndian.PutUint32(out[20:], x5+s[5])
+	binary.LittleEndian.PutUint32(out[24:], x6+s[6])
+	binary.LittleEndian.PutUint32(out[28:], x7+s[7])
+	binary.LittleEndian.PutUint32(out[32:], x8+s[8])
+	binary.LittleEndian.PutUint32(out[36:], x9+s[9])
+	binary.LittleEndian.PutUint32(out[40:], x10+s[10])
+	binary.LittleEndian.PutUint32(out[44:], x11+s[11])
+	binary.LittleEndian.PutUint32(out[48:], x12+s[12])
+	binary.LittleEndian.PutUint32(out[52:], x13+s[13])
+	binary.LittleEndian.PutUint32(out[56:], x14+s[14])
+	binary.LittleEndian.PutUint32(out[60:], x15+s[15])
+}
+
+func salsaQuarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	b ^= bits.RotateLeft32(a+d, 7)
+	c ^= bits.RotateLeft32(b+a, 9)
+	d ^= bits.RotateLeft32(c+b, 13)
+	a ^= bits.RotateLeft32(d+c, 18)
+	return a, b, c, d
+}
@@ -0,0 +1,110 @@
+package chacha
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSalsa20(t *testing.T) {
+	tests := []struct {
+		key    []byte
+		iv     []byte
+		rounds int
+		seek   uint64
+		want   string
+	}{
+		{
+			// eSTREAM Salsa20/20, 256-bit key, set 1, vector 0
+			key:    append([]byte{0x80}, make([]byte, 31)...),
+			iv:     make([]byte, 8),
+			rounds: 20,
+			want: "e3be8fdd8beca2e3ea8ef9475b29a6e7" +
+				"003951e1097a5c38d23b7a5fad9f6844" +
+				"b22c97559e2723c7cbbd3fe4fc8d9a07" +
+				"44652a83e72a9c461876af4d7ef1a117",
+		},
+		{
+			// Salsa20/8, block 1: a regression value generated by this
+			// package, not a published vector
+			key: []byte{
+				0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+				16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
+			},
+			iv:     []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			rounds: 8,
+			seek:   1,
+			want: "c7f9f03cf1802c2c193da3daa8089e97" +
+				"7314fd5b2aa5bd15b1568196cc98f37b" +
+				"8221d9a4e4790ea7709c5c3d673a0d05" +
+				"a2027933c868ed282c2acec5a239f5b7",
+		},
+	}
+	for _, test := range tests {
+		want, _ := hex.DecodeString(test.want)
+		c, err := NewSalsa20(test.key, test.iv, test.rounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Seek(test.seek)
+		got := make([]byte, len(want))
+		c.XORKeyStream(got, got)
+		if !bytes.Equal(got, want) {
+			t.Errorf("NewSalsa20(), got %x, want %x", got, want)
+		}
+	}
+
+	// RFC 7914, section 8: the Salsa20/8 core used by scrypt, on a raw
+	// state given in Salsa20's word order
+	in, _ := hex.DecodeString("" +
+		"7e879a214f3ec9867ca940e641718f26baee555b8c61c1b50df846116dcd3b1d" +
+		"ee24f319df9b3d8514121e4b5ac5aa3276021d2909c74829edebc68db8b8c25e")
+	core, _ := hex.DecodeString("" +
+		"a41f859c6608cc993b81cacb020cef05044b2181a2fd337dfd7b1c6396682f29" +
+		"b4393168e3c9e6bcfe6bc5b7a06d96bae424cc102c91745c24ad673dc7618f81")
+	var w [16]uint32
+	for i := range w {
+		w[i] = binary.LittleEndian.Uint32(in[i*4:])
+	}
+	state := [16]uint32{
+		w[0], w[5], w[10], w[15], w[1], w[2], w[3], w[4],
+		w[11], w[12], w[13], w[14], w[8], w[9], w[6], w[7],
+	}
+	var out [BlockSize]byte
+	salsaBlock(&out, &state, 8)
+	if !bytes.Equal(out[:], core) {
+		t.Errorf("Salsa20/8 core, got %x, want %x", out, core)
+	}
+
+	// Several blocks at once, from x/crypto/salsa20
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	want, _ := hex.DecodeString("" +
+		"2d8626a68e241c92749dc7efa74b6ee4b86f375ea5fef57c0d7c5d431c17dc3c" +
+		"dc87684cf21de0336c440a48569906510c3524e9a11077ce75c23321ce4afcdc" +
+		"09aab4c3d031467ab246a5732bce5b2c486243475819666bf9a698f90d3cb64b" +
+		"4b482e5eed4d0323e7d6548d3445ed9a4db2326f14ddcbacee90caeb3a8a4bd6" +
+		"60f1e152867a25534320f73c0ef3af0c815e4db1384a36f03914a87c54ada749" +
+		"ddc396002857c3d39da2c6e1f0f03f83e0959d83b65444fc5d400333f11e06d3" +
+		"578d885c5c5a51aef16cc20f9c22cc17c83d5d264dd2f6be3256b90668cce720" +
+		"1911648a1922708766c5fdfb33ffad8aff35cb57d6e597d09a4bfd9419e2d48e")
+	c, _ := NewSalsa20(key, []byte{1, 2, 3, 4, 5, 6, 7, 8}, 20)
+	got := make([]byte, len(want))
+	c.XORKeyStream(got, got)
+	if !bytes.Equal(got, want) {
+		t.Errorf("XORKeyStream(), got %x, want %x", got, want)
+	}
+
+	// The variant survives serialization
+	saved, _ := c.MarshalBinary()
+	c.Read(want[:100])
+	var d Cipher
+	d.UnmarshalBinary(saved)
+	d.Read(got[:100])
+	if !bytes.Equal(got[:100], want[:100]) {
+		t.Errorf("UnmarshalBinary(), got %x, want %x", got[:100], want[:100])
+	}
+}
@@ -0,0 +1,77 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+// Sealer computes ChaCha20-Poly1305 incrementally, for when the
+// additional data or plaintext arrives in pieces. All additional data
+// must be added before the first call to Encrypt. The outputs of
+// Encrypt followed by Tag, concatenated, are identical to
+// NewAEAD(key, 20).Seal over the concatenated inputs. A Sealer is not
+// safe for concurrent use.
+type Sealer struct {
+	c      *Cipher
+	mac    *poly1305
+	adlen  int
+	ctlen  uint64
+	sealed bool // Encrypt has been called
+	tag    []byte
+}
+
+// NewSealer returns a Sealer for the given key and nonce. The key must be
+// exactly 32 bytes and the nonce exactly 12 bytes, and a nonce must never
+// be reused with the same key.
+func NewSealer(key, nonce []byte) (*Sealer, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	if len(nonce) != NonceSizeIETF {
+		return nil, ErrNonceSize
+	}
+	a := aead{rounds: 20}
+	copy(a.key[:], key)
+	s := new(Sealer)
+	s.c, s.mac = a.setup(nonce, nil)
+	return s, nil
+}
+
+// AddAAD authenticates p as additional data. It panics if called after
+// Encrypt or Tag.
+func (s *Sealer) AddAAD(p []byte) {
+	if s.sealed || s.tag != nil {
+		panic("chacha: AddAAD after Encrypt")
+	}
+	s.mac.update(p)
+	s.adlen += len(p)
+}
+
+// Encrypt encrypts and authenticates p, returning the ciphertext in a
+// new slice. It panics if called after Tag.
+func (s *Sealer) Encrypt(p []byte) []byte {
+	if s.tag != nil {
+		panic("chacha: Encrypt after Tag")
+	}
+	if uint64(len(p)) > maxMessage-s.ctlen {
+		panic("chacha: plaintext too large")
+	}
+	if !s.sealed {
+		s.mac.pad()
+		s.sealed = true
+	}
+	out := make([]byte, len(p))
+	s.c.XORKeyStream(out, p)
+	s.mac.update(out)
+	s.ctlen += uint64(len(p))
+	return out
+}
+
+// Tag finishes the Sealer and returns the 16-byte authentication tag.
+// Later calls return the same tag.
+func (s *Sealer) Tag() []byte {
+	if s.tag == nil {
+		var tag [tagSize]byte
+		finish(s.mac, s.adlen, int(s.ctlen), &tag)
+		s.tag = tag[:]
+		s.c.Zeroize()
+	}
+	return append([]byte(nil), s.tag...)
+}
@@ -0,0 +1,60 @@
+package chacha
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealer(t *testing.T) {
+	key := make([]byte, 32)
+	nonce := make([]byte, 12)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	aad := []byte("some additional data, in pieces")
+	plaintext := bytes.Repeat([]byte("plaintext "), 13)
+
+	a, _ := NewAEAD(key, 20)
+	want := a.Seal(nil, nonce, plaintext, aad)
+
+	// Split both inputs at awkward, non-block-aligned offsets
+	for _, split := range []int{0, 1, 15, 17, 64, 100} {
+		s, err := NewSealer(key, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		as := split % len(aad)
+		s.AddAAD(aad[:as])
+		s.AddAAD(aad[as:])
+		got := s.Encrypt(plaintext[:split])
+		got = append(got, s.Encrypt(plaintext[split:])...)
+		got = append(got, s.Tag()...)
+		if !bytes.Equal(got, want) {
+			t.Errorf("Sealer(split %d), got %x, want %x", split, got, want)
+		}
+	}
+
+	// No additional data and no plaintext
+	s, _ := NewSealer(key, nonce)
+	if got, want := s.Tag(), a.Seal(nil, nonce, nil, nil); !bytes.Equal(got, want) {
+		t.Errorf("Sealer(empty), got %x, want %x", got, want)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("AddAAD() after Encrypt() did not panic")
+			}
+		}()
+		s, _ := NewSealer(key, nonce)
+		s.Encrypt(plaintext)
+		s.AddAAD(aad)
+	}()
+
+	if _, err := NewSealer(key[:16], nonce); err != ErrKeySize {
+		t.Errorf("NewSealer(short key), got %v, want %v", err, ErrKeySize)
+	}
+	if _, err := NewSealer(key, nonce[:8]); err != ErrNonceSize {
+		t.Errorf("NewSealer(short nonce), got %v, want %v", err, ErrNonceSize)
+	}
+}
@@ -0,0 +1,119 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+)
+
+// Known answers from RFC 8439, as hexadecimal.
+const (
+	// Appendix A.1, test vectors #1 and #2: all-zero key and nonce
+	selfTestZeroBlocks = "" +
+		"76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7" +
+		"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586" +
+		"9f07e7be5551387a98ba977c732d080dcb0f29a048e3656912c6533e32ee7aed" +
+		"29b721769ce64e43d57133b074d839d531ed1f28510afb45ace10a1f4b794d6f"
+
+	// Section 2.4.2
+	selfTestCiphertext = "" +
+		"6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b" +
+		"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8" +
+		"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736" +
+		"5af90bbf74a35be6b40b8eedf2785e42874d"
+
+	// Section 2.5.2
+	selfTestPolyKey = "" +
+		"85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b"
+	selfTestPolyTag = "a8061dc1305136c6c22b8baf0c0127a9"
+
+	// Section 2.8.2
+	selfTestSealed = "" +
+		"d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6" +
+		"3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36" +
+		"92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc" +
+		"3ff4def08e4b7a9de576d26586cec64b61161ae10b594f09e26a7e902ecbd060" +
+		"0691"
+
+	selfTestPlaintext = "Ladies and Gentlemen of the class of '99: " +
+		"If I could offer you only one tip for the future, " +
+		"sunscreen would be it."
+)
+
+// SelfTest checks the ChaCha20 keystream, Poly1305, and ChaCha20-Poly1305
+// against the RFC 8439 test vectors, returning an error naming the first
+// that does not match. It is intended as a power-on self-test.
+func SelfTest() error {
+	mustHex := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			panic(err)
+		}
+		return b
+	}
+
+	// Keystream, including a seek back over generated output
+	var zero [32]byte
+	want := mustHex(selfTestZeroBlocks)
+	c := New(zero[:], zero[:8], 20)
+	c.Seek(1)
+	got := make([]byte, len(want))
+	c.XORKeyStream(got[64:], got[64:])
+	c.Seek(0)
+	c.XORKeyStream(got[:64], got[:64])
+	if !bytes.Equal(got, want) {
+		return errors.New("chacha: self-test failed: keystream")
+	}
+
+	// Encryption with the IETF layout
+	key := make([]byte, KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce := []byte{0, 0, 0, 0, 0, 0, 0, 0x4a, 0, 0, 0, 0}
+	plaintext := []byte(selfTestPlaintext)
+	want = mustHex(selfTestCiphertext)
+	c, _ = NewIETF(key, nonce, 20)
+	c.Seek(1)
+	got = make([]byte, len(plaintext))
+	c.XORKeyStream(got, plaintext)
+	if !bytes.Equal(got, want) {
+		return errors.New("chacha: self-test failed: encryption")
+	}
+
+	// Poly1305
+	var polyKey [32]byte
+	var tag [16]byte
+	copy(polyKey[:], mustHex(selfTestPolyKey))
+	mac := newPoly1305(&polyKey)
+	mac.update([]byte("Cryptographic Forum Research Group"))
+	mac.sum(&tag)
+	if !bytes.Equal(tag[:], mustHex(selfTestPolyTag)) {
+		return errors.New("chacha: self-test failed: poly1305")
+	}
+
+	// AEAD
+	for i := range key {
+		key[i] = byte(0x80 + i)
+	}
+	nonce = []byte{7, 0, 0, 0, 0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47}
+	aad := []byte{
+		0x50, 0x51, 0x52, 0x53, 0xc0, 0xc1, 0xc2, 0xc3, 0xc4, 0xc5, 0xc6, 0xc7,
+	}
+	want = mustHex(selfTestSealed)
+	a, _ := NewAEAD(key, 20)
+	if !bytes.Equal(a.Seal(nil, nonce, plaintext, aad), want) {
+		return errors.New("chacha: self-test failed: seal")
+	}
+	opened, err := a.Open(nil, nonce, want, aad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		return errors.New("chacha: self-test failed: open")
+	}
+	want[0] ^= 1
+	if _, err := a.Open(nil, nonce, want, aad); err == nil {
+		return errors.New("chacha: self-test failed: forgery")
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package chacha
+
+import (
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Error(err)
+	}
+}
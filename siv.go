@@ -0,0 +1,78 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"encoding/binary"
+)
+
+// sivSize is the length of the synthetic IV prepended by SealDeterministic.
+const sivSize = NonceSizeX
+
+// SealDeterministic encrypts and authenticates plaintext, and
+// authenticates aad, without a caller-supplied nonce. The nonce is a
+// synthetic IV: a keyed pseudorandom function of the aad and plaintext,
+// computed with an HChaCha20 cascade under a subkey of key. It is
+// prepended to an XChaCha20-Poly1305 ciphertext, under a second subkey,
+// so the result is 40 bytes longer than the plaintext. The key must be
+// exactly 32 bytes, or SealDeterministic panics.
+//
+// Identical inputs produce identical outputs, so an observer learns when
+// the same message is sealed twice, but nothing else: nonce reuse is not
+// possible, and distinct messages collide only with negligible
+// probability. The cost is two passes over the plaintext, and the whole
+// message must be in memory. Prefer a randomized AEAD, such as NewXAEAD,
+// when unique nonces can be guaranteed.
+func SealDeterministic(key, plaintext, aad []byte) []byte {
+	if len(key) != KeySize {
+		panic(ErrKeySize)
+	}
+	encKey, macKey := sivKeys(key)
+	siv := sivNonce(&macKey, plaintext, aad)
+	a := &xaead{key: encKey}
+	return a.Seal(siv[:], siv[:], plaintext, aad)
+}
+
+// OpenDeterministic decrypts and authenticates a ciphertext produced by
+// SealDeterministic with the same key and aad. It also checks that the
+// synthetic IV matches the decrypted plaintext.
+func OpenDeterministic(key, ciphertext, aad []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	if len(ciphertext) < sivSize+tagSize {
+		return nil, ErrTagMismatch
+	}
+	encKey, macKey := sivKeys(key)
+	nonce := ciphertext[:sivSize]
+	a := &xaead{key: encKey}
+	plaintext, err := a.Open(nil, nonce, ciphertext[sivSize:], aad)
+	if err != nil {
+		return nil, err
+	}
+	siv := sivNonce(&macKey, plaintext, aad)
+	if !VerifyTag(siv[:], nonce) {
+		return nil, ErrTagMismatch
+	}
+	return plaintext, nil
+}
+
+// Derives independent encryption and PRF subkeys from a master key.
+func sivKeys(key []byte) (encKey, macKey [32]byte) {
+	encKey = deriveContextKey(key, []byte("chacha siv encryption"))
+	macKey = deriveContextKey(key, []byte("chacha siv nonce"))
+	return
+}
+
+// Computes the synthetic IV for the aad and plaintext. The aad length is
+// encoded so that the boundary between the two is unambiguous.
+func sivNonce(macKey *[32]byte, plaintext, aad []byte) [sivSize]byte {
+	msg := make([]byte, 8+len(aad)+len(plaintext))
+	binary.LittleEndian.PutUint64(msg, uint64(len(aad)))
+	copy(msg[8:], aad)
+	copy(msg[8+len(aad):], plaintext)
+	sum := deriveContextKey(macKey[:], msg)
+	var siv [sivSize]byte
+	copy(siv[:], sum[:])
+	return siv
+}
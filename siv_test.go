@@ -0,0 +1,65 @@
+package chacha
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealDeterministic(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	plaintext := []byte("the quick brown fox")
+	aad := []byte("header")
+
+	sealed := SealDeterministic(key, plaintext, aad)
+	if len(sealed) != len(plaintext)+40 {
+		t.Errorf("len(SealDeterministic()), got %d, want %d",
+			len(sealed), len(plaintext)+40)
+	}
+	if again := SealDeterministic(key, plaintext, aad); !bytes.Equal(sealed, again) {
+		t.Errorf("SealDeterministic() not deterministic")
+	}
+	opened, err := OpenDeterministic(key, sealed, aad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("OpenDeterministic(), got %q, %v, want %q", opened, err, plaintext)
+	}
+
+	// Moving bytes between aad and plaintext changes the output
+	others := [][2][]byte{
+		{[]byte("the quick brown fox."), aad},
+		{plaintext, []byte("header.")},
+		{[]byte("rthe quick brown fox"), []byte("heade")},
+		{plaintext, nil},
+	}
+	for _, o := range others {
+		s := SealDeterministic(key, o[0], o[1])
+		if bytes.Equal(s[:24], sealed[:24]) {
+			t.Errorf("SealDeterministic(%q, %q) reused the IV", o[0], o[1])
+		}
+	}
+
+	// Any modification, including to the IV, is rejected
+	for i := range sealed {
+		bad := append([]byte(nil), sealed...)
+		bad[i] ^= 1
+		if _, err := OpenDeterministic(key, bad, aad); err == nil {
+			t.Errorf("OpenDeterministic(flipped byte %d) succeeded", i)
+		}
+	}
+	if _, err := OpenDeterministic(key, sealed, nil); err == nil {
+		t.Errorf("OpenDeterministic(wrong aad) succeeded")
+	}
+	if _, err := OpenDeterministic(key, sealed[:39], aad); err == nil {
+		t.Errorf("OpenDeterministic(short) succeeded")
+	}
+	if _, err := OpenDeterministic(key[:16], sealed, aad); err != ErrKeySize {
+		t.Errorf("OpenDeterministic(short key), got %v, want %v", err, ErrKeySize)
+	}
+
+	empty := SealDeterministic(key, nil, nil)
+	if opened, err := OpenDeterministic(key, empty, nil); err != nil || len(opened) != 0 {
+		t.Errorf("OpenDeterministic(empty), got %q, %v", opened, err)
+	}
+}
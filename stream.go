@@ -0,0 +1,260 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"errors"
+	"io"
+)
+
+var errClosed = errors.New("chacha: write to closed EncryptWriter")
+
+// EncryptWriter encrypts and authenticates a stream with ChaCha20-Poly1305
+// without buffering it. The complete output, including the tag written by
+// Close, is identical to NewAEAD(key, 20).Seal with no additional data.
+type EncryptWriter struct {
+	w     io.Writer
+	c     *Cipher
+	mac   *poly1305
+	total uint64
+	err   error
+	buf   [16 * 1024]byte
+}
+
+// NewEncryptWriter returns an EncryptWriter writing ciphertext to w. The
+// key must be exactly 32 bytes and the nonce exactly 12 bytes, and a
+// nonce must never be reused with the same key.
+func NewEncryptWriter(w io.Writer, key, nonce []byte) (*EncryptWriter, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	if len(nonce) != NonceSizeIETF {
+		return nil, ErrNonceSize
+	}
+	a := aead{rounds: 20}
+	copy(a.key[:], key)
+	e := &EncryptWriter{w: w}
+	e.c, e.mac = a.setup(nonce, nil)
+	return e, nil
+}
+
+// Write encrypts p and writes the ciphertext to the underlying writer.
+// Any error is sticky, and the stream cannot be completed afterwards.
+func (e *EncryptWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	if uint64(len(p)) > maxMessage-e.total {
+		e.err = ErrExhausted
+		return 0, e.err
+	}
+
+	var n int
+	for len(p) > 0 {
+		chunk := e.buf[:copy(e.buf[:], p)]
+		m, err := e.encrypt(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// ReadFrom implements io.ReaderFrom, reading from r until io.EOF directly
+// into the internal buffer, encrypting it in place, and writing it out.
+// This lets io.Copy avoid a separate buffer and copy. Errors are sticky,
+// as with Write, and the stream is not closed.
+func (e *EncryptWriter) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	for e.err == nil {
+		m, rerr := r.Read(e.buf[:])
+		if uint64(m) > maxMessage-e.total {
+			e.err = ErrExhausted
+			break
+		}
+		if m > 0 {
+			m, err := e.encrypt(e.buf[:m])
+			n += int64(m)
+			if err != nil {
+				return n, err
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+	return n, e.err
+}
+
+// Encrypts chunk in place, authenticates it, and writes it out, recording
+// any error as sticky.
+func (e *EncryptWriter) encrypt(chunk []byte) (int, error) {
+	e.c.XORKeyStream(chunk, chunk)
+	e.mac.update(chunk)
+	e.total += uint64(len(chunk))
+	m, err := e.w.Write(chunk)
+	if err == nil && m < len(chunk) {
+		err = io.ErrShortWrite
+	}
+	e.err = err
+	return m, err
+}
+
+// Close writes the 16-byte authentication tag. It does not close the
+// underlying writer.
+func (e *EncryptWriter) Close() error {
+	if e.err != nil {
+		if e.err == errClosed {
+			return nil
+		}
+		return e.err
+	}
+	var tag [tagSize]byte
+	finish(e.mac, 0, int(e.total), &tag)
+	e.err = errClosed
+	if _, err := e.w.Write(tag[:]); err != nil {
+		e.err = err
+		return err
+	}
+	return nil
+}
+
+// DecryptReader decrypts a stream produced by EncryptWriter. The final
+// 16 bytes are held back as the tag and verified when the underlying
+// reader reaches EOF. Only an io.EOF from Read indicates a successful
+// verification: plaintext returned before then is unauthenticated and
+// must not be acted upon until the stream has been read to the end.
+type DecryptReader struct {
+	r     io.Reader
+	c     *Cipher
+	mac   *poly1305
+	total uint64
+	n     int // bytes held in buf
+	eof   bool
+	err   error
+	buf   [16*1024 + tagSize]byte
+}
+
+// NewDecryptReader returns a DecryptReader reading ciphertext from r, with
+// the same key and nonce requirements as NewEncryptWriter.
+func NewDecryptReader(r io.Reader, key, nonce []byte) (*DecryptReader, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	if len(nonce) != NonceSizeIETF {
+		return nil, ErrNonceSize
+	}
+	a := aead{rounds: 20}
+	copy(a.key[:], key)
+	d := &DecryptReader{r: r}
+	d.c, d.mac = a.setup(nonce, nil)
+	return d, nil
+}
+
+// Read decrypts into p. At the end of the stream it returns io.EOF if the
+// tag is valid, ErrTruncated if the stream ended before a complete tag,
+// or ErrTagMismatch if the tag is wrong. A stream truncated anywhere
+// after the first 16 bytes still has a complete, but wrong, tag, so only
+// the first error is a reliable sign of truncation rather than tampering.
+func (d *DecryptReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for !d.eof && d.err == nil && d.n <= tagSize {
+		m, err := d.r.Read(d.buf[d.n:])
+		d.n += m
+		if err == io.EOF {
+			d.eof = true
+		} else if err != nil {
+			d.err = err
+		}
+	}
+
+	if d.err != nil {
+		return 0, d.err
+	}
+	if avail := d.n - tagSize; avail > 0 {
+		if avail > len(p) {
+			avail = len(p)
+		}
+		if uint64(avail) > maxMessage-d.total {
+			d.err = ErrTagMismatch
+			return 0, d.err
+		}
+		ciphertext := d.buf[:avail]
+		d.mac.update(ciphertext)
+		d.c.XORKeyStream(p, ciphertext)
+		d.total += uint64(avail)
+		d.n = copy(d.buf[:], d.buf[avail:d.n])
+		return avail, nil
+	}
+
+	switch {
+	case !d.eof:
+		return 0, nil
+	case d.n != tagSize:
+		d.err = ErrTruncated
+		return 0, d.err
+	}
+	var want [tagSize]byte
+	finish(d.mac, 0, int(d.total), &want)
+	if !VerifyTag(want[:], d.buf[:tagSize]) {
+		d.err = ErrTagMismatch
+	} else {
+		d.err = io.EOF
+	}
+	return 0, d.err
+}
+
+// EncryptStream encrypts everything read from src to dst with
+// ChaCha20-Poly1305, under a random nonce that it writes first as a
+// 12-byte header, and returns that nonce. The rest of the output is an
+// EncryptWriter stream. The key must be exactly 32 bytes. Errors from
+// src, dst, or crypto/rand are returned as is.
+func EncryptStream(dst io.Writer, src io.Reader, key []byte) (nonce []byte, err error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	n, err := GenerateNonceIETF()
+	if err != nil {
+		return nil, err
+	}
+	nonce = n[:]
+	w, _ := NewEncryptWriter(dst, key, nonce)
+	if _, err := dst.Write(nonce); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// DecryptStream decrypts a stream produced by EncryptStream from src to
+// dst, returning nil only if the stream was authenticated. As with
+// DecryptReader, plaintext is written to dst before the tag is checked at
+// the end, so on error everything written to dst must be discarded. A
+// stream too short for its header or tag gives ErrTruncated.
+func DecryptStream(dst io.Writer, src io.Reader, key []byte) error {
+	if len(key) != KeySize {
+		return ErrKeySize
+	}
+	var nonce [NonceSizeIETF]byte
+	if _, err := io.ReadFull(src, nonce[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	r, _ := NewDecryptReader(src, key, nonce[:])
+	_, err := io.Copy(dst, r)
+	return err
+}
@@ -0,0 +1,204 @@
+package chacha
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEncryptWriter(t *testing.T) {
+	key := make([]byte, 32)
+	nonce := make([]byte, 12)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce[0] = 7
+	a, _ := NewAEAD(key, 20)
+
+	for _, size := range []int{0, 1, 15, 16, 17, 64, 1000, 40000} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i * 7)
+		}
+		want := a.Seal(nil, nonce, plaintext, nil)
+
+		var buf bytes.Buffer
+		w, err := NewEncryptWriter(&buf, key, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p := plaintext; len(p) > 0; {
+			n := 1 + len(p)%13
+			if n > len(p) {
+				n = len(p)
+			}
+			w.Write(p[:n])
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("EncryptWriter, size %d, got %x, want %x",
+				size, buf.Bytes(), want)
+		}
+		if _, err := w.Write([]byte{0}); err == nil {
+			t.Errorf("Write() after Close(), got nil error")
+		}
+
+		// io.Copy goes through ReadFrom, with the same results
+		buf.Reset()
+		w, _ = NewEncryptWriter(&buf, key, nonce)
+		if _, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(plaintext))); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("EncryptWriter ReadFrom, size %d, got %x, want %x",
+				size, buf.Bytes(), want)
+		}
+
+		readers := []io.Reader{
+			bytes.NewReader(want),
+			iotest.OneByteReader(bytes.NewReader(want)),
+			iotest.DataErrReader(bytes.NewReader(want)),
+		}
+		for _, r := range readers {
+			d, err := NewDecryptReader(r, key, nonce)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("DecryptReader, size %d, got %x, want %x",
+					size, got, plaintext)
+			}
+		}
+
+		// An empty Read after the source reports EOF, while plaintext is
+		// still buffered, must not end the stream early
+		d, _ := NewDecryptReader(iotest.DataErrReader(bytes.NewReader(want)), key, nonce)
+		var got []byte
+		one := make([]byte, 1)
+		for {
+			if n, err := d.Read(nil); n != 0 || err != nil {
+				t.Fatalf("Read(nil), size %d, got %d, %v, want 0, nil", size, n, err)
+			}
+			n, err := d.Read(one)
+			got = append(got, one[:n]...)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("DecryptReader, size %d, got %v", size, err)
+			}
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("DecryptReader(empty reads), size %d, got %x, want %x",
+				size, got, plaintext)
+		}
+
+		// Any modification, including truncation, fails
+		bad := [][]byte{want[:len(want)-1], append(want, 0)}
+		for i := 0; i < len(want); i += 1 + len(want)/8 {
+			b := append([]byte(nil), want...)
+			b[i] ^= 1
+			bad = append(bad, b)
+		}
+		for _, b := range bad {
+			d, _ := NewDecryptReader(bytes.NewReader(b), key, nonce)
+			if _, err := io.ReadAll(d); err == nil {
+				t.Errorf("DecryptReader(%x), got nil error", b)
+			}
+		}
+
+		// Streams too short for a tag are reported as truncated
+		for _, n := range []int{0, 1, tagSize - 1} {
+			if n > len(want) {
+				continue
+			}
+			d, _ := NewDecryptReader(bytes.NewReader(want[:n]), key, nonce)
+			_, err := io.ReadAll(d)
+			if err != ErrTruncated || !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("DecryptReader(%d bytes), got %v, want %v",
+					n, err, ErrTruncated)
+			}
+		}
+		b := append([]byte(nil), want...)
+		b[len(b)-1] ^= 1
+		d, _ = NewDecryptReader(bytes.NewReader(b), key, nonce)
+		if _, err := io.ReadAll(d); err != ErrTagMismatch {
+			t.Errorf("DecryptReader(bad tag), got %v, want %v", err, ErrTagMismatch)
+		}
+	}
+
+	if _, err := NewEncryptWriter(io.Discard, key, nonce[:8]); err != ErrNonceSize {
+		t.Errorf("NewEncryptWriter(), got %v, want %v", err, ErrNonceSize)
+	}
+	if _, err := NewDecryptReader(nil, key[:16], nonce); err != ErrKeySize {
+		t.Errorf("NewDecryptReader(), got %v, want %v", err, ErrKeySize)
+	}
+}
+
+func TestEncryptStream(t *testing.T) {
+	key := make([]byte, 32)
+	key[0] = 1
+	plaintext := bytes.Repeat([]byte("0123456789"), 5000)
+
+	var buf bytes.Buffer
+	nonce, err := EncryptStream(&buf, iotest.HalfReader(bytes.NewReader(plaintext)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := buf.Bytes()
+	if !bytes.Equal(sealed[:NonceSizeIETF], nonce) {
+		t.Errorf("EncryptStream() header, got %x, want %x", sealed[:NonceSizeIETF], nonce)
+	}
+	a, _ := NewAEAD(key, 20)
+	if want := a.Seal(nil, nonce, plaintext, nil); !bytes.Equal(sealed[NonceSizeIETF:], want) {
+		t.Errorf("EncryptStream() body differs from Seal()")
+	}
+	again, _ := EncryptStream(io.Discard, bytes.NewReader(plaintext), key)
+	if bytes.Equal(again, nonce) {
+		t.Errorf("EncryptStream() repeated a nonce")
+	}
+
+	var out bytes.Buffer
+	if err := DecryptStream(&out, iotest.OneByteReader(bytes.NewReader(sealed)), key); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Errorf("DecryptStream(), wrong plaintext")
+	}
+
+	bad := append([]byte(nil), sealed...)
+	bad[len(bad)/2] ^= 1
+	if err := DecryptStream(io.Discard, bytes.NewReader(bad), key); err != ErrTagMismatch {
+		t.Errorf("DecryptStream(modified), got %v, want %v", err, ErrTagMismatch)
+	}
+	for _, n := range []int{0, 5, NonceSizeIETF + 3} {
+		if err := DecryptStream(io.Discard, bytes.NewReader(sealed[:n]), key); err != ErrTruncated {
+			t.Errorf("DecryptStream(%d bytes), got %v, want %v", n, err, ErrTruncated)
+		}
+	}
+
+	werr := errors.New("write failed")
+	if _, err := EncryptStream(&failWriter{werr}, bytes.NewReader(plaintext), key); err != werr {
+		t.Errorf("EncryptStream(failing writer), got %v, want %v", err, werr)
+	}
+	if _, err := EncryptStream(io.Discard, nil, key[:16]); err != ErrKeySize {
+		t.Errorf("EncryptStream(short key), got %v, want %v", err, ErrKeySize)
+	}
+}
+
+type failWriter struct {
+	err error
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
@@ -0,0 +1,43 @@
+// This is free and unencumbered software released into the public domain.
+
+package chacha
+
+import (
+	"crypto/cipher"
+	"io"
+	"sync"
+)
+
+// SyncCipher is a Cipher that is safe for concurrent use. Calls are
+// serialized, so each receives a contiguous run of keystream that does
+// not overlap with any other call's. This is a convenience for sharing
+// one stream, and it does not run any faster: for parallelism, give each
+// goroutine its own Clone, each positioned with Seek at a disjoint region
+// of the keystream.
+type SyncCipher struct {
+	mu sync.Mutex
+	c  *Cipher
+}
+
+var _ cipher.Stream = (*SyncCipher)(nil)
+var _ io.Reader = (*SyncCipher)(nil)
+
+// NewSyncCipher returns a SyncCipher wrapping c. The caller must not use c
+// directly afterwards.
+func NewSyncCipher(c *Cipher) *SyncCipher {
+	return &SyncCipher{c: c}
+}
+
+// Read is like Cipher.Read.
+func (s *SyncCipher) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c.Read(p)
+}
+
+// XORKeyStream is like Cipher.XORKeyStream, including its panics.
+func (s *SyncCipher) XORKeyStream(dst, src []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.c.XORKeyStream(dst, src)
+}
@@ -0,0 +1,55 @@
+package chacha
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestSyncCipher(t *testing.T) {
+	const (
+		workers = 8
+		reads   = 100
+		size    = 100
+	)
+	var key [32]byte
+	var iv [8]byte
+	want := make([]byte, workers*reads*size)
+	New(key[:], iv[:], 20).Read(want)
+
+	s := NewSyncCipher(New(key[:], iv[:], 20))
+	chunks := make([][]byte, workers*reads)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < reads; i++ {
+				chunk := make([]byte, size)
+				if w%2 == 0 {
+					s.Read(chunk)
+				} else {
+					s.XORKeyStream(chunk, chunk)
+				}
+				chunks[w*reads+i] = chunk
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Every chunk is a distinct, aligned run of the keystream
+	seen := make([]bool, workers*reads)
+	for _, chunk := range chunks {
+		found := false
+		for i := range seen {
+			if !seen[i] && bytes.Equal(chunk, want[i*size:(i+1)*size]) {
+				seen[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("chunk %x is not a distinct run of keystream", chunk)
+		}
+	}
+}